@@ -7,6 +7,8 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	// the installations time zones must be available in the minimal docker image.
+	_ "time/tzdata"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -17,7 +19,10 @@ func main() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 
-	config, err := config.ReadConfig()
+	flags := config.NewFlagSet(os.Args[0])
+	// ExitOnError, the usage is printed on an invalid flag.
+	_ = flags.Parse(os.Args[1:])
+	config, err := config.ReadConfig(flags)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Error found when reading the config.")
 	}
@@ -36,17 +41,21 @@ func main() {
 
 	log.Info().Msg("Starting climkit to MQTT!")
 
-	controller := controller.NewController(config)
+	// Subscribe for interruption happening during execution, including while starting.
+	exitSignal := make(chan os.Signal, 2)
+	signal.Notify(exitSignal, os.Interrupt, syscall.SIGTERM)
+
+	controller, err := controller.NewController(config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error on creating the controller")
+	}
 	if err := controller.Start(); err != nil {
 		log.Fatal().Err(err).Msg("Error on starting the controller")
 	}
 
-	// Subscribe for interruption happening during execution.
-	exitSignal := make(chan os.Signal, 2)
-	signal.Notify(exitSignal, os.Interrupt, syscall.SIGTERM)
 	<-exitSignal
 
-	// Gracefulle stop all the modules loops and logic.
+	// Gracefulle stop all the modules loops and logic, cancelling the in-flight climkit requests.
 	if err := controller.Stop(); err != nil {
 		log.Fatal().Err(err).Msg("Error when stopping the controller")
 	}
@@ -2,21 +2,40 @@ package climkit
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/rs/zerolog"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"sync"
 	"time"
 )
 
+// defaultAuthTimeout bounds the token refresh when the options do not define a RequestTimeout.
+const defaultAuthTimeout = 30 * time.Second
+
 type Interceptor struct {
-	core        http.RoundTripper
-	options     ClientOptions
+	core http.RoundTripper
+	// authClient sends the token requests through the same TLS configuration as the data requests.
+	authClient *http.Client
+	options    ClientOptions
+	log        zerolog.Logger
+
+	// mu guards the token and the in-flight refresh, the interceptor is shared by concurrent requests.
+	mu          sync.Mutex
 	accessToken string
 	validUntil  time.Time
-	log         zerolog.Logger
+	refresh     *tokenRefresh
+}
+
+// tokenRefresh is a token request shared by all the requests waiting for a new token.
+type tokenRefresh struct {
+	done  chan struct{}
+	token string
+	err   error
 }
 
 type AuthRequest struct {
@@ -31,69 +50,162 @@ type AuthResponse struct {
 	} `json:"valid_until"`
 }
 
-func NewInterceptor(logger zerolog.Logger, options *ClientOptions) *Interceptor {
+// NewInterceptor creates the transport authenticating the requests. The data and token requests take a token of the
+// limiter, which may be nil to disable the limit.
+func NewInterceptor(logger zerolog.Logger, options *ClientOptions, limiter *RateLimiter) (*Interceptor, error) {
+	tlsConfig, err := options.TLS.tlsConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS configuration: %w", err)
+	}
+	if tlsConfig.InsecureSkipVerify {
+		logger.Warn().Msg("TLS certificate verification of the Climkit API is disabled")
+	}
+	core := NewRateLimitTransport(limiter, &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	})
+
 	return &Interceptor{
-		core: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			}},
+		core:        core,
+		authClient:  &http.Client{Transport: core},
 		options:     *options,
 		accessToken: "",
 		validUntil:  time.Now().Add(-time.Hour),
 		log:         logger,
-	}
+	}, nil
 }
 
-func (i *Interceptor) refreshTokenIfNecessary() (string, error) {
-	if time.Now().Add(10 * time.Second).After(i.validUntil) {
+// getToken returns a valid token, renewing it if it is about to expire or if it is the rejectedToken. Only one
+// refresh runs at a time, the concurrent callers wait for its result or for their own context.
+func (i *Interceptor) getToken(ctx context.Context, rejectedToken string) (string, error) {
+	i.mu.Lock()
+	if i.accessToken != "" && i.accessToken != rejectedToken && time.Now().Add(10*time.Second).Before(i.validUntil) {
+		token := i.accessToken
+		i.mu.Unlock()
+		return token, nil
+	}
+	refresh := i.refresh
+	if refresh == nil {
 		i.log.Debug().Msg("Token is expired, renewing")
+		refresh = &tokenRefresh{done: make(chan struct{})}
+		i.refresh = refresh
+		go i.runRefresh(refresh)
+	}
+	i.mu.Unlock()
 
-		requestBody := AuthRequest{
-			Username: i.options.Username,
-			Password: i.options.Password,
-		}
-		jsonRequest, _ := json.Marshal(requestBody)
-		response, err := http.Post(i.options.ApiUrl+"v1/auth", "application/json", bytes.NewBuffer(jsonRequest))
-		if err != nil {
-			return "", fmt.Errorf("unable to get accessToken: %w", err)
-		}
-
-		body, readErr := ioutil.ReadAll(response.Body)
-		if readErr != nil {
-			return "", fmt.Errorf("error reading the response: %w", err)
-		}
+	select {
+	case <-refresh.done:
+		return refresh.token, refresh.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
 
-		var jsonResponse AuthResponse
-		json.Unmarshal(body, &jsonResponse)
+// runRefresh requests a new token. It is detached from the context of the request that triggered it, since other
+// requests may be waiting for the same token, and is bounded by the RequestTimeout instead.
+func (i *Interceptor) runRefresh(refresh *tokenRefresh) {
+	timeout := i.options.RequestTimeout
+	if timeout <= 0 {
+		timeout = defaultAuthTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-		i.accessToken = jsonResponse.AccessToken
-		i.validUntil = time.UnixMilli(jsonResponse.ValidUntil.Date)
+	token, validUntil, err := i.requestToken(ctx)
 
-		i.log.Info().Str("access_token", i.accessToken).Time("valid_until", i.validUntil).Msg("Token received")
+	i.mu.Lock()
+	if err == nil {
+		i.accessToken = token
+		i.validUntil = validUntil
+		i.log.Info().Time("valid_until", validUntil).Msg("Token received")
+	} else {
+		i.log.Error().Err(err).Msg("Unable to get accessToken")
 	}
-	return i.accessToken, nil
+	refresh.token = token
+	refresh.err = err
+	i.refresh = nil
+	i.mu.Unlock()
+	close(refresh.done)
 }
 
-func (i *Interceptor) modifyRequest(r *http.Request) *http.Request {
-	token, err := i.refreshTokenIfNecessary()
+func (i *Interceptor) requestToken(ctx context.Context) (string, time.Time, error) {
+	requestBody := AuthRequest{
+		Username: i.options.Username,
+		Password: i.options.Password,
+	}
+	jsonRequest, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("cannot serialize auth request: %w", err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, i.options.ApiUrl+"v1/auth", bytes.NewBuffer(jsonRequest))
 	if err != nil {
-		i.log.Err(err).Msg("Unable to get accessToken ")
+		return "", time.Time{}, fmt.Errorf("unable to create auth request: %w", err)
 	}
-	i.log.Trace().Str("token", token).Msg("Injecting accessToken")
-	r.Header.Set("Authorization", "Bearer "+token)
-	return r
+	request.Header.Set("Content-Type", "application/json")
+	response, err := i.authClient.Do(request)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("unable to get accessToken: %w", err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error reading the auth response: %w", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("authentication failed: %w", newAPIError("v1/auth", response, body))
+	}
+
+	var jsonResponse AuthResponse
+	if err := json.Unmarshal(body, &jsonResponse); err != nil {
+		return "", time.Time{}, fmt.Errorf("unable to unmarshal the auth response: %w", err)
+	}
+	if jsonResponse.AccessToken == "" {
+		return "", time.Time{}, errors.New("authentication failed: no access token in the response")
+	}
+	return jsonResponse.AccessToken, time.UnixMilli(jsonResponse.ValidUntil.Date), nil
+}
+
+// withToken returns a copy of the request with the token injected, the original request must not be modified.
+func (i *Interceptor) withToken(r *http.Request, token string) *http.Request {
+	i.log.Trace().Msg("Injecting accessToken")
+	newReq := r.Clone(r.Context())
+	newReq.Header.Set("Authorization", "Bearer "+token)
+	return newReq
 }
 
 func (i *Interceptor) RoundTrip(r *http.Request) (*http.Response, error) {
-	defer func() {
+	token, err := i.getToken(r.Context(), "")
+	if err != nil {
 		if r.Body != nil {
 			_ = r.Body.Close()
 		}
-	}()
+		return nil, fmt.Errorf("unable to authenticate: %w", err)
+	}
+
+	resp, err := i.core.RoundTrip(i.withToken(r, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
 
-	// modify before the request is sent
-	newReq := i.modifyRequest(r)
+	// The token was rejected before its expiration (revoked, clock skew...). Re-authenticate and replay the request
+	// once, if its body can be replayed.
+	if r.Body != nil && r.Body != http.NoBody && r.GetBody == nil {
+		return resp, nil
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+	i.log.Warn().Str("url", r.URL.Path).Msg("Token rejected, re-authenticating")
 
-	// send the request using the DefaultTransport
-	return i.core.RoundTrip(newReq)
+	token, err = i.getToken(r.Context(), token)
+	if err != nil {
+		return nil, fmt.Errorf("unable to re-authenticate: %w", err)
+	}
+	replay := i.withToken(r, token)
+	if r.GetBody != nil {
+		if replay.Body, err = r.GetBody(); err != nil {
+			return nil, fmt.Errorf("cannot replay request to %s: %w", r.URL.Path, err)
+		}
+	}
+	return i.core.RoundTrip(replay)
 }
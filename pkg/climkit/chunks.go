@@ -0,0 +1,142 @@
+package climkit
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// timeChunk is a part [start, end) of a requested time range.
+type timeChunk struct {
+	start time.Time
+	end   time.Time
+}
+
+// splitTimeRange splits [start, end) into consecutive chunks of at most interval. A non positive interval returns
+// the whole range as a single chunk.
+func splitTimeRange(start time.Time, end time.Time, interval time.Duration) []timeChunk {
+	if !start.Before(end) {
+		return nil
+	}
+	if interval <= 0 {
+		return []timeChunk{{start: start, end: end}}
+	}
+	var chunks []timeChunk
+	for chunkStart := start; chunkStart.Before(end); chunkStart = chunkStart.Add(interval) {
+		chunkEnd := chunkStart.Add(interval)
+		if chunkEnd.After(end) {
+			chunkEnd = end
+		}
+		chunks = append(chunks, timeChunk{start: chunkStart, end: chunkEnd})
+	}
+	return chunks
+}
+
+// chunkResult is the outcome of the request of one chunk.
+type chunkResult[T any] struct {
+	items []T
+	err   error
+}
+
+// fetchChunks collects the series streamed by streamChunks. On error, the items of the chunks preceding the failing
+// one are returned with the error, so the caller can store them and resume from there.
+func fetchChunks[T any](ctx context.Context, c *Client, start time.Time, end time.Time, fetch func(ctx context.Context, start time.Time, end time.Time) ([]T, error), timestamp func(T) time.Time) ([]T, error) {
+	items, errs := streamChunks(ctx, c, start, end, fetch, timestamp)
+	var series []T
+	for item := range items {
+		series = append(series, item)
+	}
+	return series, <-errs
+}
+
+// streamChunks splits [start, end) according to the ChunkInterval option and fetches the chunks, up to
+// ChunkConcurrency at a time. The items are sent in one series ordered by timestamp, without the duplicates returned
+// by overlapping chunks, as soon as their chunk and the previous ones are received.
+//
+// The items channel is closed when the series is complete, on the first error or when the context is cancelled. The
+// error channel then receives the error, if any, and is closed. At most ChunkConcurrency+1 chunks are held in memory,
+// a consumer that stops reading must cancel the context.
+func streamChunks[T any](ctx context.Context, c *Client, start time.Time, end time.Time, fetch func(ctx context.Context, start time.Time, end time.Time) ([]T, error), timestamp func(T) time.Time) (<-chan T, <-chan error) {
+	items := make(chan T)
+	errs := make(chan error, 1)
+
+	concurrency := c.options.ChunkConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	chunks := splitTimeRange(start, end, c.options.ChunkInterval)
+
+	go func() {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		defer close(errs)
+		defer close(items)
+
+		// fetching limits the concurrent requests, buffered the chunks received but not sent yet.
+		fetching := make(chan struct{}, concurrency)
+		buffered := make(chan struct{}, concurrency+1)
+		results := make(chan chan chunkResult[T], len(chunks))
+		go func() {
+			defer close(results)
+			for _, chunk := range chunks {
+				select {
+				case buffered <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+				result := make(chan chunkResult[T], 1)
+				results <- result
+				go func(chunk timeChunk) {
+					select {
+					case fetching <- struct{}{}:
+					case <-ctx.Done():
+						result <- chunkResult[T]{err: ctx.Err()}
+						return
+					}
+					chunkItems, err := fetch(ctx, chunk.start, chunk.end)
+					<-fetching
+					result <- chunkResult[T]{items: chunkItems, err: err}
+				}(chunk)
+			}
+		}()
+
+		var last time.Time
+		sent := false
+		for result := range results {
+			var chunk chunkResult[T]
+			select {
+			case chunk = <-result:
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			}
+			if chunk.err != nil {
+				errs <- chunk.err
+				return
+			}
+			sort.SliceStable(chunk.items, func(i, j int) bool {
+				return timestamp(chunk.items[i]).Before(timestamp(chunk.items[j]))
+			})
+			for _, item := range chunk.items {
+				// the chunks may overlap on their bounds, only the first value of a timestamp is kept.
+				if sent && !timestamp(item).After(last) {
+					continue
+				}
+				select {
+				case items <- item:
+				case <-ctx.Done():
+					errs <- ctx.Err()
+					return
+				}
+				last = timestamp(item)
+				sent = true
+			}
+			<-buffered
+		}
+		if err := ctx.Err(); err != nil {
+			errs <- err
+		}
+	}()
+
+	return items, errs
+}
@@ -2,22 +2,29 @@ package climkit
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"io/ioutil"
 	"net/http"
-	"strings"
+	"sync"
 	"time"
 )
 
 const ClimkitTimeFormat = "2006-01-02 15:04:05"
+const ClimkitMeterTimeFormat = "2006-01-02T15:04:05"
 
 type Client struct {
 	options    ClientOptions
 	httpClient *http.Client
-	log        zerolog.Logger
+	// cache is nil if the metadata cache is disabled.
+	cache *MetadataCache
+	// locations is shared by all the copies of the client.
+	locations *locationRegistry
+	log       zerolog.Logger
 }
 
 type InstallationInfo struct {
@@ -42,14 +49,38 @@ type MeterInfo struct {
 	Virtual bool   `json:"virtual"`
 }
 
+type Sensor struct {
+	Id   string `json:"id"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Unit string `json:"unit"`
+}
+
 type MeterDataItem struct {
-	MeterId string
-	Ext     float64
-	Self    float64
-	Total   float64
+	MeterId  string
+	Ext      float64
+	Self     float64
+	Total    float64
+	Sessions int
+}
+
+// SingleMeterData is one point of the time series of a single meter.
+type SingleMeterData struct {
+	MeterDataItem
+	Timestamp time.Time
+	// Interval is the length of the sample.
+	Interval time.Duration
+}
+
+// RawReading is the cumulative register (index) value of a meter at a given time, as read on the meter.
+type RawReading struct {
+	MeterId   string
+	Value     float64
+	Timestamp time.Time
 }
 
 type MeterData struct {
+	Type                    MeterType
 	ConsoTotal              float64
 	FromExt                 float64
 	ProdTotal               float64
@@ -59,162 +90,412 @@ type MeterData struct {
 	ToExt                   float64
 	Meters                  []MeterDataItem
 	Timestamp               time.Time
+	// Interval is the length of the sample, the values are the energies or volumes measured during it.
+	Interval time.Duration
+	// Extra holds the numeric columns of the response that are not decoded into the fields above.
+	Extra map[string]float64
 }
 
-type MeterType string
-
-const (
-	Electricity MeterType = "electricity"
-	Heating               = "heating"
-	ColdWater             = "cold_water"
-	HotWater              = "hot_water"
-	ChargePoint           = "charge_point"
-)
+// Quantity types a value of this point, like ProdTotal or the Total of one of the Meters.
+func (d MeterData) Quantity(value float64) Quantity {
+	return NewQuantity(d.Type, value, d.Interval)
+}
 
 type TimeSeriesRequest struct {
-	// Should be ISO8601 but without timezone !
+	// Should be ISO8601 but without timezone, as a wall clock time of the installation time zone !
 	StartTime string `json:"t_s"`
 	EndTime   string `json:"t_e"`
 }
 
-func NewClient(options *ClientOptions) Client {
-	logger := log.With().Str("Component", "Climkit").Logger()
-	interceptor := NewInterceptor(logger, options)
+func NewClient(options *ClientOptions) (Client, error) {
+	logContext := log.With().Str("Component", "Climkit")
+	if options.Label != "" {
+		logContext = logContext.Str("account", options.Label)
+	}
+	logger := logContext.Logger()
+	interceptor, err := NewInterceptor(logger, options, NewRateLimiter(logger, options.RateLimit, options.RateBurst))
+	if err != nil {
+		return Client{}, err
+	}
+	if err := options.Filter.validate(); err != nil {
+		return Client{}, err
+	}
+	if err := options.Recording.validate(); err != nil {
+		return Client{}, err
+	}
+	var transport http.RoundTripper = interceptor
+	switch options.Recording.Mode {
+	case RecordingRecord:
+		if transport, err = NewRecordTransport(logger, options, interceptor); err != nil {
+			return Client{}, err
+		}
+	case RecordingReplay:
+		if transport, err = NewReplayTransport(logger, options); err != nil {
+			return Client{}, err
+		}
+	}
+	// with a single attempt, the retry transport still bounds it with the RequestTimeout.
+	transport = NewRetryTransport(logger, options, transport)
+
+	var cache *MetadataCache
+	if options.CacheTTL > 0 || options.CacheFile != "" {
+		cache = NewMetadataCache(logger, options.CacheTTL, options.CacheFile)
+	}
 
 	return Client{
 		httpClient: &http.Client{
-			Transport: interceptor,
+			Transport: transport,
 		},
-		options: *options,
-		log:     logger,
-	}
+		cache:     cache,
+		locations: newLocationRegistry(),
+		options:   *options,
+		log:       logger,
+	}, nil
+}
+
+type RawTimeSeriesRequest struct {
+	// Should be ISO8601 but without timezone, as a wall clock time of the installation time zone !
+	StartTime string `json:"t_s"`
 }
 
 func (c *Client) GetInstallationIds() ([]string, error) {
-	var obj []string
-	err := c.get("installations", "v1/all_installations", &obj)
-	return obj, err
+	return c.GetInstallationIdsContext(context.Background())
+}
+
+// GetInstallationIdsContext returns the ids of the installations allowed by the filter. The site ref filter requires
+// the info of each installation, an installation whose info cannot be fetched is left out until the next call.
+func (c *Client) GetInstallationIdsContext(ctx context.Context) ([]string, error) {
+	installationIds, err := c.cache.installationIds(ctx, func() ([]string, error) {
+		var obj []string
+		err := c.get(ctx, "installations", "v1/all_installations", &obj)
+		return obj, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var allowed []string
+	for _, installationId := range installationIds {
+		if c.options.Filter.checkInstallation(installationId) != nil {
+			continue
+		}
+		if c.options.Filter.filtersSiteRefs() {
+			if _, err := c.GetInstallationInfoContext(ctx, installationId); err != nil {
+				if ctx.Err() != nil {
+					return nil, err
+				}
+				if !errors.Is(err, ErrFiltered) {
+					c.log.Warn().Err(err).Str("installationId", installationId).Msg("Unable to check the site ref, installation ignored")
+				}
+				continue
+			}
+		}
+		allowed = append(allowed, installationId)
+	}
+	return allowed, nil
 }
 
 func (c *Client) GetInstallationInfo(installationId string) (InstallationInfo, error) {
-	var obj InstallationInfo
-	err := c.get("installation info", "v1/installation_infos/"+installationId, &obj)
-	return obj, err
+	return c.GetInstallationInfoContext(context.Background(), installationId)
+}
+
+func (c *Client) GetInstallationInfoContext(ctx context.Context, installationId string) (InstallationInfo, error) {
+	if err := c.options.Filter.checkInstallation(installationId); err != nil {
+		return InstallationInfo{}, err
+	}
+	info, err := c.cache.installationInfo(ctx, installationId, func() (InstallationInfo, error) {
+		var obj InstallationInfo
+		err := c.get(ctx, "installation info", "v1/installation_infos/"+installationId, &obj)
+		return obj, err
+	})
+	if err != nil {
+		return info, err
+	}
+	if !c.options.Filter.SiteRefs.Match(info.SiteRef) {
+		return InstallationInfo{}, fmt.Errorf("site ref %s: %w", info.SiteRef, ErrFiltered)
+	}
+	c.updateLocation(installationId, info)
+	return info, nil
 }
 
 func (c *Client) GetMetersInfos(installationId string) ([]MeterInfo, error) {
-	var obj []MeterInfo
-	err := c.get("meters info", "v1/meter_info/"+installationId, &obj)
+	return c.GetMetersInfosContext(context.Background(), installationId)
+}
+
+// GetMetersInfosContext returns the meters of the installation allowed by the filter.
+func (c *Client) GetMetersInfosContext(ctx context.Context, installationId string) ([]MeterInfo, error) {
+	if err := c.options.Filter.checkInstallation(installationId); err != nil {
+		return nil, err
+	}
+	meters, err := c.metersInfos(ctx, installationId)
+	return c.options.Filter.FilterMeters(meters), err
+}
+
+// metersInfos returns all the meters of the installation, before the filter.
+func (c *Client) metersInfos(ctx context.Context, installationId string) ([]MeterInfo, error) {
+	return c.cache.metersInfos(ctx, installationId, func() ([]MeterInfo, error) {
+		var obj []MeterInfo
+		err := c.get(ctx, "meters info", "v1/meter_info/"+installationId, &obj)
+		return obj, err
+	})
+}
+
+// InvalidateCache drops the cached installations and meters, the next calls query the API. Does nothing if the cache
+// is disabled.
+func (c *Client) InvalidateCache() {
+	c.cache.Invalidate()
+}
+
+// InvalidateInstallationCache drops the cached info and meters of one installation.
+func (c *Client) InvalidateInstallationCache(installationId string) {
+	c.cache.InvalidateInstallation(installationId)
+}
+
+func (c *Client) GetSensors(installationId string) ([]Sensor, error) {
+	return c.GetSensorsContext(context.Background(), installationId)
+}
+
+func (c *Client) GetSensorsContext(ctx context.Context, installationId string) ([]Sensor, error) {
+	if err := c.options.Filter.checkInstallation(installationId); err != nil {
+		return nil, err
+	}
+	var obj []Sensor
+	err := c.get(ctx, "sensors list", "v1/"+installationId+"/sensors_list", &obj)
 	return obj, err
 }
 
+// GetMeterData returns the time series of the meters of the given type between startTime and endTime, ordered by
+// timestamp. Meters of other types are ignored. Long ranges are split in several requests according to the
+// ChunkInterval option.
 func (c *Client) GetMeterData(installationId string, meters []MeterInfo, meterType MeterType, startTime time.Time, endTime time.Time) ([]MeterData, error) {
-	var obj []interface{}
+	return c.GetMeterDataContext(context.Background(), installationId, meters, meterType, startTime, endTime)
+}
 
-	// implicit UTC
-	formattedStartTime := startTime.UTC().Format(ClimkitTimeFormat)
-	formattedEndTime := endTime.UTC().Format(ClimkitTimeFormat)
+func (c *Client) GetMeterDataContext(ctx context.Context, installationId string, meters []MeterInfo, meterType MeterType, startTime time.Time, endTime time.Time) ([]MeterData, error) {
+	return fetchChunks(ctx, c, startTime, endTime, func(ctx context.Context, startTime time.Time, endTime time.Time) ([]MeterData, error) {
+		return c.getMeterDataChunk(ctx, installationId, meters, meterType, startTime, endTime)
+	}, func(data MeterData) time.Time {
+		return data.Timestamp
+	})
+}
 
+// StreamMeterData is the streaming variant of GetMeterData: the values are sent on the returned channel as soon as
+// their request is received, while the next ones are downloading. The error channel receives the error, if any, once
+// the values channel is closed. Cancel the context to stop the stream early.
+func (c *Client) StreamMeterData(ctx context.Context, installationId string, meters []MeterInfo, meterType MeterType, startTime time.Time, endTime time.Time) (<-chan MeterData, <-chan error) {
+	return streamChunks(ctx, c, startTime, endTime, func(ctx context.Context, startTime time.Time, endTime time.Time) ([]MeterData, error) {
+		return c.getMeterDataChunk(ctx, installationId, meters, meterType, startTime, endTime)
+	}, func(data MeterData) time.Time {
+		return data.Timestamp
+	})
+}
+
+func (c *Client) getMeterDataChunk(ctx context.Context, installationId string, meters []MeterInfo, meterType MeterType, startTime time.Time, endTime time.Time) ([]MeterData, error) {
+	if err := c.options.Filter.checkInstallation(installationId); err != nil {
+		return nil, err
+	}
+	if !c.options.Filter.MeterTypes.Match(string(meterType)) {
+		return nil, fmt.Errorf("meter type %s: %w", meterType, ErrFiltered)
+	}
+	location, err := c.installationLocation(ctx, installationId)
+	if err != nil {
+		return nil, err
+	}
+	var obj []map[string]json.RawMessage
+
+	// wall clock times of the installation
 	request := TimeSeriesRequest{
-		StartTime: formattedStartTime,
-		EndTime:   formattedEndTime,
+		StartTime: formatLocal(startTime, location, ClimkitTimeFormat),
+		EndTime:   formatLocal(endTime, location, ClimkitTimeFormat),
 	}
 
-	err := c.getHistory("meters data", "v1/site_data/"+installationId+"/"+string(meterType), request, &obj)
+	err = c.getHistory(ctx, "meters data", "v1/site_data/"+installationId+"/"+string(meterType), request, &obj)
 
-	var meterDataArray []MeterData
+	resolver := newTimeResolver(location, startTime)
+	meterDataArray, warnings := decodeMeterData(obj, c.options.Filter.FilterMeters(FilterMetersByType(meters, meterType)), meterType, resolver)
+	c.logDecodeWarnings("meters data", warnings)
+	// the site data also contains the columns of the filtered meters.
+	for i := range meterDataArray {
+		meterDataArray[i].Meters = c.filterMeterItems(meterDataArray[i].Meters)
+	}
 
-	for i := 0; i < len(obj); i++ {
-		rawJson := obj[i].(map[string]interface{})
+	return meterDataArray, err
+}
 
-		var meterDataItemArray []MeterDataItem
+// GetSingleMeterData returns the time series of only one meter, which is much smaller than the whole site data. Long
+// ranges are split as in GetMeterData.
+func (c *Client) GetSingleMeterData(installationId string, meterId string, startTime time.Time, endTime time.Time) ([]SingleMeterData, error) {
+	return c.GetSingleMeterDataContext(context.Background(), installationId, meterId, startTime, endTime)
+}
 
-		for j := 0; j < len(meters); j++ {
-			meter := meters[j]
-			item := MeterDataItem{
-				MeterId: meter.Id,
-			}
-			if rawJson["ext_"+meter.Id] != nil {
-				item.Ext = parse64AndLogError(rawJson["ext_"+meter.Id])
-			}
-			if rawJson["self_"+meter.Id] != nil {
-				item.Ext = parse64AndLogError(rawJson["self_"+meter.Id])
-			}
-			if rawJson["total_"+meter.Id] != nil {
-				item.Total = parse64AndLogError(rawJson["total_"+meter.Id])
-			}
-			meterDataItemArray = append(meterDataItemArray, item)
+func (c *Client) GetSingleMeterDataContext(ctx context.Context, installationId string, meterId string, startTime time.Time, endTime time.Time) ([]SingleMeterData, error) {
+	checkType := c.meterTypeCheck(installationId, meterId)
+	return fetchChunks(ctx, c, startTime, endTime, func(ctx context.Context, startTime time.Time, endTime time.Time) ([]SingleMeterData, error) {
+		if err := checkType(ctx); err != nil {
+			return nil, err
 		}
+		return c.getSingleMeterDataChunk(ctx, installationId, meterId, startTime, endTime)
+	}, func(data SingleMeterData) time.Time {
+		return data.Timestamp
+	})
+}
 
-		meterData := MeterData{
-			ConsoTotal:              parse64AndLogError(rawJson["conso_total"]),
-			FromExt:                 parse64AndLogError(rawJson["from_ext"]),
-			ProdTotal:               parse64AndLogError(rawJson["prod_total"]),
-			Self:                    parse64AndLogError(rawJson["self"]),
-			StorageChargingTotal:    parse64AndLogError(rawJson["storage_charging_total"]),
-			StorageDischargingTotal: parse64AndLogError(rawJson["storage_discharging_total"]),
-			ToExt:                   parse64AndLogError(rawJson["to_ext"]),
-			Timestamp:               parseTimeAndLogError(rawJson["timestamp"]),
-			Meters:                  meterDataItemArray,
+// StreamSingleMeterData is the streaming variant of GetSingleMeterData, see StreamMeterData.
+func (c *Client) StreamSingleMeterData(ctx context.Context, installationId string, meterId string, startTime time.Time, endTime time.Time) (<-chan SingleMeterData, <-chan error) {
+	checkType := c.meterTypeCheck(installationId, meterId)
+	return streamChunks(ctx, c, startTime, endTime, func(ctx context.Context, startTime time.Time, endTime time.Time) ([]SingleMeterData, error) {
+		if err := checkType(ctx); err != nil {
+			return nil, err
 		}
+		return c.getSingleMeterDataChunk(ctx, installationId, meterId, startTime, endTime)
+	}, func(data SingleMeterData) time.Time {
+		return data.Timestamp
+	})
+}
 
-		meterDataArray = append(meterDataArray, meterData)
+func (c *Client) getSingleMeterDataChunk(ctx context.Context, installationId string, meterId string, startTime time.Time, endTime time.Time) ([]SingleMeterData, error) {
+	if err := c.options.Filter.checkMeter(installationId, meterId); err != nil {
+		return nil, err
 	}
+	location, err := c.installationLocation(ctx, installationId)
+	if err != nil {
+		return nil, err
+	}
+	var obj []map[string]json.RawMessage
+
+	// wall clock times of the installation
+	request := TimeSeriesRequest{
+		StartTime: formatLocal(startTime, location, ClimkitMeterTimeFormat),
+		EndTime:   formatLocal(endTime, location, ClimkitMeterTimeFormat),
+	}
+
+	err = c.getHistory(ctx, "single meter data", "v1/meter_data/"+installationId+"/"+meterId, request, &obj)
+
+	meterDataArray, warnings := decodeSingleMeterData(obj, meterId, newTimeResolver(location, startTime))
+	c.logDecodeWarnings("single meter data", warnings)
 
 	return meterDataArray, err
 }
 
-func parse64AndLogError(input interface{}) float64 {
-	//str := input.(string)
-	//float, err := strconv.ParseFloat(str, 64)
-	//if err != nil {
-	//	log.Err(err).Str("str", str).Msg("Unable to parse float")
-	//	return 0.0
-	//}
-	return input.(float64)
+// GetMeterRawData returns the raw register readings of one meter, starting at startTime. Unlike GetMeterData, the
+// values are the cumulative indexes of the meter and not the consumption of each interval.
+func (c *Client) GetMeterRawData(installationId string, meterId string, startTime time.Time) ([]RawReading, error) {
+	return c.GetMeterRawDataContext(context.Background(), installationId, meterId, startTime)
 }
 
-func parseTimeAndLogError(input interface{}) time.Time {
-	str := input.(string)
-	str = strings.Replace(str, " ", "T", 1) // fix timestamp format to ISO8601
-	parsed, err := time.Parse(time.RFC3339, str)
+func (c *Client) GetMeterRawDataContext(ctx context.Context, installationId string, meterId string, startTime time.Time) ([]RawReading, error) {
+	if err := c.options.Filter.checkMeter(installationId, meterId); err != nil {
+		return nil, err
+	}
+	if err := c.meterTypeCheck(installationId, meterId)(ctx); err != nil {
+		return nil, err
+	}
+	location, err := c.installationLocation(ctx, installationId)
 	if err != nil {
-		log.Err(err).Str("str", str).Msg("Unable to parse time")
+		return nil, err
+	}
+	var obj []map[string]json.RawMessage
+
+	// wall clock time of the installation
+	request := RawTimeSeriesRequest{
+		StartTime: formatLocal(startTime, location, ClimkitMeterTimeFormat),
+	}
+
+	err = c.getHistory(ctx, "meter raw data", "v1/meter_data_raw/"+installationId+"/"+meterId, request, &obj)
+
+	readings, warnings := decodeRawReadings(obj, meterId, newTimeResolver(location, startTime))
+	c.logDecodeWarnings("meter raw data", warnings)
+
+	return readings, err
+}
+
+// meterTypeCheck returns a check rejecting the meter if its type is filtered out, as GetMetersInfos would. The type is
+// resolved from the meters info once, and only if the meter type filter is set. A meter missing from the meters info
+// is rejected, its type cannot be allowed.
+func (c *Client) meterTypeCheck(installationId string, meterId string) func(ctx context.Context) error {
+	var once sync.Once
+	var err error
+	return func(ctx context.Context) error {
+		if !c.options.Filter.filtersMeterTypes() {
+			return nil
+		}
+		once.Do(func() {
+			var meters []MeterInfo
+			meters, err = c.metersInfos(ctx, installationId)
+			if err != nil {
+				return
+			}
+			meter, found := FindMeter(meters, meterId)
+			if !found {
+				err = fmt.Errorf("meter %s of unknown type: %w", meterId, ErrFiltered)
+			} else if !c.options.Filter.MeterTypes.Match(meter.Type) {
+				err = fmt.Errorf("meter %s of type %s: %w", meterId, meter.Type, ErrFiltered)
+			}
+		})
+		return err
 	}
-	return parsed
 }
 
-func (c *Client) get(methodName string, path string, returnObject any) error {
+func (c *Client) filterMeterItems(items []MeterDataItem) []MeterDataItem {
+	var filtered []MeterDataItem
+	for _, item := range items {
+		if c.options.Filter.Meters.Match(item.MeterId) {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
+
+// logDecodeWarnings reports the fields that could not be decoded. The decoding never fails on a single bad field, the
+// field is left to zero or the row skipped.
+func (c *Client) logDecodeWarnings(methodName string, warnings []DecodeWarning) {
+	for _, warning := range warnings {
+		c.log.Warn().Str("methodName", methodName).Str("field", warning.Field).Str("value", warning.Value).
+			Err(warning.Err).Msg("Unable to decode field")
+	}
+}
+
+func (c *Client) get(ctx context.Context, methodName string, path string, returnObject any) error {
 	c.log.Info().Str("methodName", methodName).Msg("Get request")
-	resp, err := c.httpClient.Get(c.options.ApiUrl + path)
-	return c.handleHttpResponse(methodName, resp, err, returnObject)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.options.ApiUrl+path, nil)
+	if err != nil {
+		return fmt.Errorf("cannot create request %s: %w", methodName, err)
+	}
+	resp, err := c.httpClient.Do(req)
+	return c.handleHttpResponse(methodName, path, resp, err, returnObject)
 }
 
-func (c *Client) getHistory(methodName string, path string, request TimeSeriesRequest, returnObject any) error {
+func (c *Client) getHistory(ctx context.Context, methodName string, path string, request any, returnObject any) error {
 	jsonRequest, err := json.Marshal(request)
 	c.log.Info().Str("methodName", methodName).Str("request", string(jsonRequest)).Msg("Get history")
 	if err != nil {
 		return fmt.Errorf("cannot serialize request %s: %w", methodName, err)
 	}
-	resp, err := c.httpClient.Post(c.options.ApiUrl+path, "application/json", bytes.NewBuffer(jsonRequest))
-	return c.handleHttpResponse(methodName, resp, err, returnObject)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.options.ApiUrl+path, bytes.NewBuffer(jsonRequest))
+	if err != nil {
+		return fmt.Errorf("cannot create request %s: %w", methodName, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient.Do(req)
+	return c.handleHttpResponse(methodName, path, resp, err, returnObject)
 }
 
-func (c *Client) handleHttpResponse(methodName string, resp *http.Response, err error, returnObject any) error {
+func (c *Client) handleHttpResponse(methodName string, path string, resp *http.Response, err error, returnObject any) error {
 	if err != nil {
 		return fmt.Errorf("unable to get %s: %w", methodName, err)
 	}
+	defer resp.Body.Close()
 
 	body, readErr := ioutil.ReadAll(resp.Body)
 	if readErr != nil {
-		return fmt.Errorf("error reading the request for %s: %w", methodName, err)
+		return fmt.Errorf("error reading the request for %s: %w", methodName, readErr)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error with request to get %s: %w", methodName, newAPIError(path, resp, body))
 	}
 	err = json.Unmarshal(body, &returnObject)
 	if err != nil {
 		return fmt.Errorf("unable to unmarshal body when getting %s. Status: %s, body: %s, err=%w", methodName, resp.Status, string(body), err)
-	} else if resp.StatusCode != 200 {
-		return fmt.Errorf("error with request to get %s. Status: %s, body: %s", methodName, resp.Status, string(body))
-	} else {
-		return nil
 	}
+	return nil
 }
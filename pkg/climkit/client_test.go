@@ -0,0 +1,200 @@
+package climkit_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gaetancollaud/climkit/pkg/climkit"
+	"github.com/gaetancollaud/climkit/pkg/climkit/climkittest"
+)
+
+func newTestServer(t *testing.T) *climkittest.Server {
+	t.Helper()
+	server := climkittest.NewServer()
+	t.Cleanup(server.Close)
+	server.AddInstallation("inst-1", climkit.InstallationInfo{Name: "Home", Timezone: "Europe/Zurich"})
+	server.AddMeter("inst-1", climkit.MeterInfo{Id: "m-1", Type: string(climkit.Electricity)})
+	return server
+}
+
+func newTestClient(t *testing.T, options *climkit.ClientOptions) climkit.Client {
+	t.Helper()
+	client, err := climkit.NewClient(options)
+	if err != nil {
+		t.Fatalf("unable to create the client: %v", err)
+	}
+	return client
+}
+
+func TestClientReplaysAfterRevokedToken(t *testing.T) {
+	server := newTestServer(t)
+	client := newTestClient(t, server.ClientOptions())
+
+	if _, err := client.GetInstallationIds(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	server.RevokeTokens()
+	ids, err := client.GetInstallationIds()
+	if err != nil {
+		t.Fatalf("the request was not replayed with a new token: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != "inst-1" {
+		t.Errorf("got %v, want [inst-1]", ids)
+	}
+	if count := server.RequestCount("v1/auth"); count != 2 {
+		t.Errorf("got %d token requests, want 2", count)
+	}
+	if count := server.RequestCount("v1/all_installations"); count != 3 {
+		t.Errorf("got %d requests, want the rejected one replayed once", count)
+	}
+}
+
+func TestClientInvalidCredentials(t *testing.T) {
+	server := newTestServer(t)
+	client := newTestClient(t, server.ClientOptions().SetPassword("wrong"))
+
+	_, err := client.GetInstallationIds()
+	if !climkit.IsUnauthorized(err) {
+		t.Fatalf("got %v, want an unauthorized error", err)
+	}
+	if count := server.RequestCount("v1/all_installations"); count != 0 {
+		t.Errorf("got %d requests without a token, want none", count)
+	}
+}
+
+func TestClientFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		failure climkittest.Failure
+		// attempts is the number of attempts of the client, the default when zero.
+		attempts int
+		check    func(error) bool
+		// requests is the number of requests received by the endpoint.
+		requests int
+	}{
+		{
+			name:     "rate limit retried",
+			failure:  climkittest.RateLimitFailure("v1/installation_infos/*", 0, 2),
+			check:    func(err error) bool { return err == nil },
+			requests: 3,
+		},
+		{
+			name:     "rate limit without retry",
+			failure:  climkittest.RateLimitFailure("v1/installation_infos/*", time.Second, 1),
+			attempts: 1,
+			check: func(err error) bool {
+				return climkit.IsRateLimited(err) && climkit.RetryAfter(err) == time.Second
+			},
+			requests: 1,
+		},
+		{
+			name:     "server error retried",
+			failure:  climkittest.StatusFailure("v1/installation_infos/*", http.StatusInternalServerError, 2),
+			check:    func(err error) bool { return err == nil },
+			requests: 3,
+		},
+		{
+			name:     "server error exhausting the attempts",
+			failure:  climkittest.StatusFailure("v1/installation_infos/*", http.StatusInternalServerError, 0),
+			attempts: 2,
+			check:    climkit.IsServerError,
+			requests: 2,
+		},
+		{
+			name:     "not found",
+			failure:  climkittest.StatusFailure("v1/installation_infos/*", http.StatusNotFound, 0),
+			check:    climkit.IsNotFound,
+			requests: 1,
+		},
+		{
+			name:    "malformed JSON",
+			failure: climkittest.MalformedFailure("v1/installation_infos/*", 1),
+			check: func(err error) bool {
+				var apiErr *climkit.APIError
+				return err != nil && !errors.As(err, &apiErr)
+			},
+			requests: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t)
+			server.InjectFailure(test.failure)
+			options := server.ClientOptions()
+			if test.attempts > 0 {
+				options.SetRetryMaxAttempts(test.attempts)
+			}
+			client := newTestClient(t, options)
+
+			_, err := client.GetInstallationInfo("inst-1")
+			if !test.check(err) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if count := server.RequestCount("v1/installation_infos/*"); count != test.requests {
+				t.Errorf("got %d requests, want %d", count, test.requests)
+			}
+		})
+	}
+}
+
+func TestClientSlowResponses(t *testing.T) {
+	t.Run("attempt timeout retried", func(t *testing.T) {
+		server := newTestServer(t)
+		server.InjectFailure(climkittest.SlowFailure("v1/all_installations", time.Second, 1))
+		client := newTestClient(t, server.ClientOptions().SetRequestTimeout(50*time.Millisecond))
+
+		start := time.Now()
+		if _, err := client.GetInstallationIds(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("took %s, the slow attempt was not abandoned", elapsed)
+		}
+		if count := server.RequestCount("v1/all_installations"); count != 2 {
+			t.Errorf("got %d requests, want 2", count)
+		}
+	})
+
+	t.Run("caller deadline", func(t *testing.T) {
+		server := newTestServer(t)
+		server.InjectFailure(climkittest.SlowFailure("v1/all_installations", time.Second, 0))
+		client := newTestClient(t, server.ClientOptions())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := client.GetInstallationIdsContext(ctx)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got %v, want the deadline of the caller", err)
+		}
+	})
+}
+
+func TestClientMeterData(t *testing.T) {
+	server := newTestServer(t)
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	server.GenerateSeries("inst-1", climkit.Electricity, start, start.Add(time.Hour))
+	client := newTestClient(t, server.ClientOptions())
+
+	meters, err := client.GetMetersInfos("inst-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := client.GetMeterData("inst-1", meters, climkit.Electricity, start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(data) != 4 {
+		t.Fatalf("got %d samples, want 4", len(data))
+	}
+	for i, sample := range data {
+		if want := start.Add(time.Duration(i) * 15 * time.Minute); !sample.Timestamp.Equal(want) {
+			t.Errorf("sample %d: got %s, want %s", i, sample.Timestamp, want)
+		}
+		if sample.Interval != 15*time.Minute || len(sample.Meters) != 1 || sample.Meters[0].MeterId != "m-1" {
+			t.Errorf("sample %d: got %+v", i, sample)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package climkittest
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Failure alters the responses of the endpoints matching Path. It is applied before the authentication check, so
+// that the token endpoint can fail too.
+type Failure struct {
+	// Path is a path.Match pattern of the endpoints, relative to the API URL (e.g. "v1/auth", "v1/site_data/*/*").
+	// Empty matches all the endpoints.
+	Path string
+	// Times is the number of requests affected, zero affects all of them until ClearFailures.
+	Times int
+	// Delay is waited before answering, or before the normal response if neither Status nor Body is set. The wait
+	// stops when the client gives up on the request.
+	Delay time.Duration
+	// Status is the error status returned (401, 429, 500...), with a JSON message.
+	Status int
+	// RetryAfter is sent in the Retry-After header, in seconds.
+	RetryAfter time.Duration
+	// Body replaces the response body, with the Status or 200, e.g. to return malformed JSON.
+	Body string
+
+	remaining int
+}
+
+// StatusFailure fails the next requests of the endpoints with the status, all of them if times is zero.
+func StatusFailure(path string, status int, times int) Failure {
+	return Failure{Path: path, Status: status, Times: times}
+}
+
+// RateLimitFailure answers 429 with a Retry-After header to the next requests of the endpoints.
+func RateLimitFailure(path string, retryAfter time.Duration, times int) Failure {
+	return Failure{Path: path, Status: http.StatusTooManyRequests, RetryAfter: retryAfter, Times: times}
+}
+
+// MalformedFailure answers 200 with a body that is not valid JSON to the next requests of the endpoints.
+func MalformedFailure(path string, times int) Failure {
+	return Failure{Path: path, Body: `{"truncated": [`, Times: times}
+}
+
+// SlowFailure delays the next responses of the endpoints.
+func SlowFailure(path string, delay time.Duration, times int) Failure {
+	return Failure{Path: path, Delay: delay, Times: times}
+}
+
+// InjectFailure adds a failure. When several failures match a request, the first added one applies.
+func (s *Server) InjectFailure(failure Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failure.remaining = failure.Times
+	s.failures = append(s.failures, &failure)
+}
+
+// ClearFailures removes all the injected failures.
+func (s *Server) ClearFailures() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = nil
+}
+
+// nextFailure returns the failure to apply to a request of the endpoint, if any, and consumes it. Must be called with
+// the lock held.
+func (s *Server) nextFailure(endpoint string) *Failure {
+	for i, failure := range s.failures {
+		if !matchEndpoint(failure.Path, endpoint) {
+			continue
+		}
+		if failure.Times > 0 {
+			failure.remaining--
+			if failure.remaining == 0 {
+				s.failures = append(s.failures[:i], s.failures[i+1:]...)
+			}
+		}
+		return failure
+	}
+	return nil
+}
+
+// apply waits for the delay and writes the altered response. It returns false if the request must be answered
+// normally.
+func (f *Failure) apply(w http.ResponseWriter, r *http.Request) bool {
+	if f.Delay > 0 {
+		timer := time.NewTimer(f.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			return true
+		}
+	}
+	if f.Status == 0 && f.Body == "" {
+		return false
+	}
+	if f.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(f.RetryAfter.Seconds())))
+	}
+	if f.Body == "" {
+		writeError(w, f.Status, http.StatusText(f.Status))
+		return true
+	}
+	status := f.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(f.Body))
+	return true
+}
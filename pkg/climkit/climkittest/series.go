@@ -0,0 +1,138 @@
+package climkittest
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gaetancollaud/climkit/pkg/climkit"
+)
+
+// SampleInterval is the length of the generated samples, the one of the API.
+const SampleInterval = 15 * time.Minute
+
+// Sample is one row of the site data: the columns of the installation (e.g. "prod_total", "total") and of its meters
+// (e.g. "total_<meterId>") at a timestamp. The timestamp is sent as a wall clock time of the installation.
+type Sample struct {
+	Timestamp time.Time
+	Values    map[string]float64
+}
+
+func (s Sample) row(location *time.Location, layout string) map[string]interface{} {
+	row := map[string]interface{}{
+		"timestamp": s.Timestamp.In(location).Format(layout),
+	}
+	for column, value := range s.Values {
+		row[column] = value
+	}
+	return row
+}
+
+// meterRow returns the columns of one meter, as sent by the single meter data.
+func (s Sample) meterRow(meterId string, location *time.Location) map[string]interface{} {
+	row := map[string]interface{}{
+		"timestamp": s.Timestamp.In(location).Format(climkit.ClimkitMeterTimeFormat),
+	}
+	for column, value := range s.Values {
+		if strings.HasSuffix(column, "_"+meterId) {
+			row[column] = value
+		}
+	}
+	return row
+}
+
+// GenerateSeries adds a sample every SampleInterval from start (included) to end (excluded) to the site data of the
+// meter type, with a column per seeded meter of the type. The values are deterministic: a daily production curve
+// for the electricity, constant consumptions otherwise. The meters must be added before.
+func (s *Server) GenerateSeries(installationId string, meterType climkit.MeterType, start time.Time, end time.Time) {
+	s.mu.Lock()
+	meters := climkit.FilterMetersByType(s.mustInstallation(installationId).meters, meterType)
+	s.mu.Unlock()
+
+	var samples []Sample
+	for t := start.Truncate(SampleInterval); t.Before(end); t = t.Add(SampleInterval) {
+		if t.Before(start) {
+			continue
+		}
+		samples = append(samples, generateSample(meterType, meters, t))
+	}
+	s.AddSamples(installationId, meterType, samples...)
+}
+
+func generateSample(meterType climkit.MeterType, meters []climkit.MeterInfo, t time.Time) Sample {
+	values := make(map[string]float64)
+	if meterType != climkit.Electricity {
+		total := 0.0
+		for i, meter := range meters {
+			value := 0.1 * float64(i+1)
+			values["total_"+meter.Id] = value
+			if meterType == climkit.ChargePoint {
+				values["sessions_"+meter.Id] = 1
+			}
+			total += value
+		}
+		values["total"] = total
+		return Sample{Timestamp: t, Values: values}
+	}
+
+	// production between 6:00 and 18:00 UTC, peaking at 1 kWh per sample at noon.
+	hour := float64(t.UTC().Hour()) + float64(t.UTC().Minute())/60
+	production := math.Max(0, math.Sin((hour-6)/12*math.Pi))
+	consumption := 0.0
+	for i := range meters {
+		consumption += 0.2 * float64(i+1)
+	}
+	self := math.Min(production, consumption)
+	for i, meter := range meters {
+		total := 0.2 * float64(i+1)
+		meterSelf := 0.0
+		if consumption > 0 {
+			meterSelf = self * total / consumption
+		}
+		values["total_"+meter.Id] = total
+		values["self_"+meter.Id] = meterSelf
+		values["ext_"+meter.Id] = total - meterSelf
+	}
+	values["prod_total"] = production
+	values["self"] = self
+	values["to_ext"] = production - self
+	values["conso_total"] = consumption
+	values["from_ext"] = consumption - self
+	values["storage_charging_total"] = 0
+	values["storage_discharging_total"] = 0
+	return Sample{Timestamp: t, Values: values}
+}
+
+// mergeSamples adds the samples to the ordered series, replacing the ones at the same timestamp.
+func mergeSamples(series []Sample, samples []Sample) []Sample {
+	byTime := make(map[int64]Sample, len(series)+len(samples))
+	for _, sample := range append(append([]Sample{}, series...), samples...) {
+		byTime[sample.Timestamp.UnixNano()] = sample
+	}
+	merged := make([]Sample, 0, len(byTime))
+	for _, sample := range byTime {
+		merged = append(merged, sample)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Timestamp.Before(merged[j].Timestamp)
+	})
+	return merged
+}
+
+// samplesBetween returns the samples of the window [start, end[.
+func samplesBetween(series []Sample, start time.Time, end time.Time) []Sample {
+	var samples []Sample
+	for _, sample := range series {
+		if !sample.Timestamp.Before(start) && sample.Timestamp.Before(end) {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+func sortReadings(readings []climkit.RawReading) {
+	sort.Slice(readings, func(i, j int) bool {
+		return readings[i].Timestamp.Before(readings[j].Timestamp)
+	})
+}
@@ -0,0 +1,502 @@
+// Package climkittest provides a fake of the Climkit API, to exercise the climkit client and the modules without
+// credentials nor network.
+//
+//	server := climkittest.NewServer()
+//	defer server.Close()
+//	server.AddInstallation("inst-1", climkit.InstallationInfo{Timezone: "Europe/Zurich"})
+//	server.AddMeter("inst-1", climkit.MeterInfo{Id: "m-1", Type: string(climkit.Electricity)})
+//	server.GenerateSeries("inst-1", climkit.Electricity, start, end)
+//	client, err := climkit.NewClient(server.ClientOptions())
+package climkittest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gaetancollaud/climkit/pkg/climkit"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultUsername and DefaultPassword are the credentials accepted by a new server.
+	DefaultUsername = "climkittest"
+	DefaultPassword = "climkittest"
+	// DefaultTokenTTL is the validity of the tokens returned by a new server.
+	DefaultTokenTTL = time.Hour
+	// maxRawReadings is the number of raw readings returned per request, like the API does.
+	maxRawReadings = 100
+)
+
+// Server is a fake of the Climkit API. The installations, meters and series are seeded by the test, failures can be
+// injected on any endpoint. It is safe for concurrent use.
+type Server struct {
+	server *httptest.Server
+
+	mu            sync.Mutex
+	username      string
+	password      string
+	tokenTTL      time.Duration
+	tokens        map[string]time.Time
+	installations map[string]*installation
+	// installationIds keeps the order of the installations as added.
+	installationIds []string
+	failures        []*Failure
+	requests        []string
+}
+
+type installation struct {
+	info    climkit.InstallationInfo
+	meters  []climkit.MeterInfo
+	sensors []climkit.Sensor
+	series  map[climkit.MeterType][]Sample
+	raw     map[string][]climkit.RawReading
+}
+
+// NewServer starts a fake API, accepting DefaultUsername and DefaultPassword. Close it at the end of the test.
+func NewServer() *Server {
+	s := &Server{
+		username:      DefaultUsername,
+		password:      DefaultPassword,
+		tokenTTL:      DefaultTokenTTL,
+		tokens:        make(map[string]time.Time),
+		installations: make(map[string]*installation),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Close shuts the server down, blocking until all the requests are done.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// ApiUrl is the base URL to configure in the climkit client.
+func (s *Server) ApiUrl() string {
+	return s.server.URL + "/api/"
+}
+
+// ClientOptions returns the options of a client connected to the server, without rate limit. The retries are kept,
+// disable them with SetRetryMaxAttempts(1) to observe the injected failures directly.
+func (s *Server) ClientOptions() *climkit.ClientOptions {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return climkit.NewClientOptions().
+		SetApiUrl(s.ApiUrl()).
+		SetUsername(s.username).
+		SetPassword(s.password).
+		SetRateLimit(0, 0).
+		SetRetryBackoff(time.Millisecond, 10*time.Millisecond)
+}
+
+// SetCredentials changes the accepted credentials. The tokens already issued stay valid.
+func (s *Server) SetCredentials(username string, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.username = username
+	s.password = password
+}
+
+// SetTokenTTL changes the validity of the next tokens.
+func (s *Server) SetTokenTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokenTTL = ttl
+}
+
+// RevokeTokens invalidates the issued tokens, the next requests are rejected with 401 until the client
+// re-authenticates.
+func (s *Server) RevokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = make(map[string]time.Time)
+}
+
+// AddInstallation adds or replaces an installation. An empty time zone defaults to UTC.
+func (s *Server) AddInstallation(installationId string, info climkit.InstallationInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if info.Timezone == "" {
+		info.Timezone = "UTC"
+	}
+	if existing, found := s.installations[installationId]; found {
+		existing.info = info
+		return
+	}
+	s.installations[installationId] = &installation{
+		info:   info,
+		series: make(map[climkit.MeterType][]Sample),
+		raw:    make(map[string][]climkit.RawReading),
+	}
+	s.installationIds = append(s.installationIds, installationId)
+}
+
+// RemoveInstallation removes an installation, the API then answers 404 for it.
+func (s *Server) RemoveInstallation(installationId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.installations, installationId)
+	for i, id := range s.installationIds {
+		if id == installationId {
+			s.installationIds = append(s.installationIds[:i], s.installationIds[i+1:]...)
+			break
+		}
+	}
+}
+
+// AddMeter adds a meter to an installation, which must have been added before.
+func (s *Server) AddMeter(installationId string, meter climkit.MeterInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst := s.mustInstallation(installationId)
+	inst.meters = append(inst.meters, meter)
+}
+
+// RemoveMeter removes a meter from an installation, its samples are kept.
+func (s *Server) RemoveMeter(installationId string, meterId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst := s.mustInstallation(installationId)
+	for i, meter := range inst.meters {
+		if meter.Id == meterId {
+			inst.meters = append(inst.meters[:i], inst.meters[i+1:]...)
+			return
+		}
+	}
+}
+
+// AddSensor adds a sensor to an installation.
+func (s *Server) AddSensor(installationId string, sensor climkit.Sensor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst := s.mustInstallation(installationId)
+	inst.sensors = append(inst.sensors, sensor)
+}
+
+// AddSamples adds samples to the site data of a meter type. The samples are kept ordered by timestamp, a sample at
+// the timestamp of an existing one replaces it.
+func (s *Server) AddSamples(installationId string, meterType climkit.MeterType, samples ...Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst := s.mustInstallation(installationId)
+	inst.series[meterType] = mergeSamples(inst.series[meterType], samples)
+}
+
+// AddRawReadings adds raw register readings to a meter.
+func (s *Server) AddRawReadings(installationId string, meterId string, readings ...climkit.RawReading) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst := s.mustInstallation(installationId)
+	for _, reading := range readings {
+		reading.MeterId = meterId
+		inst.raw[meterId] = append(inst.raw[meterId], reading)
+	}
+	sortReadings(inst.raw[meterId])
+}
+
+func (s *Server) mustInstallation(installationId string) *installation {
+	inst, found := s.installations[installationId]
+	if !found {
+		panic(fmt.Sprintf("climkittest: unknown installation %s, add it first", installationId))
+	}
+	return inst
+}
+
+// RequestCount returns the number of requests received on the endpoints matching the pattern, relative to the API
+// URL (e.g. "v1/site_data/*/*"), including the failed ones. An empty pattern counts all the requests.
+func (s *Server) RequestCount(pattern string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for _, endpoint := range s.requests {
+		if matchEndpoint(pattern, endpoint) {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	endpoint := strings.TrimPrefix(r.URL.Path, "/api/")
+	s.mu.Lock()
+	s.requests = append(s.requests, endpoint)
+	failure := s.nextFailure(endpoint)
+	s.mu.Unlock()
+
+	if failure != nil && failure.apply(w, r) {
+		return
+	}
+	if endpoint == "v1/auth" {
+		s.handleAuth(w, r)
+		return
+	}
+	if !s.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "invalid or expired token")
+		return
+	}
+
+	parts := strings.Split(endpoint, "/")
+	switch {
+	case len(parts) == 2 && parts[1] == "all_installations" && r.Method == http.MethodGet:
+		s.handleInstallations(w)
+	case len(parts) == 3 && parts[1] == "installation_infos" && r.Method == http.MethodGet:
+		s.handleInstallationInfo(w, parts[2])
+	case len(parts) == 3 && parts[1] == "meter_info" && r.Method == http.MethodGet:
+		s.handleMeterInfo(w, parts[2])
+	case len(parts) == 3 && parts[2] == "sensors_list" && r.Method == http.MethodGet:
+		s.handleSensors(w, parts[1])
+	case len(parts) == 4 && parts[1] == "site_data" && r.Method == http.MethodPost:
+		s.handleSiteData(w, r, parts[2], climkit.MeterType(parts[3]))
+	case len(parts) == 4 && parts[1] == "meter_data" && r.Method == http.MethodPost:
+		s.handleMeterData(w, r, parts[2], parts[3])
+	case len(parts) == 4 && parts[1] == "meter_data_raw" && r.Method == http.MethodPost:
+		s.handleRawData(w, r, parts[2], parts[3])
+	default:
+		writeError(w, http.StatusNotFound, "unknown endpoint "+endpoint)
+	}
+}
+
+func (s *Server) handleAuth(w http.ResponseWriter, r *http.Request) {
+	var request climkit.AuthRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid auth request")
+		return
+	}
+	s.mu.Lock()
+	if request.Username != s.username || request.Password != s.password {
+		s.mu.Unlock()
+		writeError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+	token := uuid.New().String()
+	validUntil := time.Now().Add(s.tokenTTL)
+	s.tokens[token] = validUntil
+	s.mu.Unlock()
+
+	var response climkit.AuthResponse
+	response.AccessToken = token
+	response.ValidUntil.Date = validUntil.UnixMilli()
+	writeJSON(w, response)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	validUntil, found := s.tokens[token]
+	return found && time.Now().Before(validUntil)
+}
+
+func (s *Server) handleInstallations(w http.ResponseWriter) {
+	s.mu.Lock()
+	ids := append([]string{}, s.installationIds...)
+	s.mu.Unlock()
+	writeJSON(w, ids)
+}
+
+func (s *Server) handleInstallationInfo(w http.ResponseWriter, installationId string) {
+	s.mu.Lock()
+	inst, found := s.installations[installationId]
+	var info climkit.InstallationInfo
+	if found {
+		info = inst.info
+	}
+	s.mu.Unlock()
+	if !found {
+		writeError(w, http.StatusNotFound, "unknown installation "+installationId)
+		return
+	}
+	writeJSON(w, info)
+}
+
+func (s *Server) handleMeterInfo(w http.ResponseWriter, installationId string) {
+	s.mu.Lock()
+	inst, found := s.installations[installationId]
+	meters := []climkit.MeterInfo{}
+	if found {
+		meters = append(meters, inst.meters...)
+	}
+	s.mu.Unlock()
+	if !found {
+		writeError(w, http.StatusNotFound, "unknown installation "+installationId)
+		return
+	}
+	writeJSON(w, meters)
+}
+
+func (s *Server) handleSensors(w http.ResponseWriter, installationId string) {
+	s.mu.Lock()
+	inst, found := s.installations[installationId]
+	sensors := []climkit.Sensor{}
+	if found {
+		sensors = append(sensors, inst.sensors...)
+	}
+	s.mu.Unlock()
+	if !found {
+		writeError(w, http.StatusNotFound, "unknown installation "+installationId)
+		return
+	}
+	writeJSON(w, sensors)
+}
+
+// handleSiteData returns the samples of the window [t_s, t_e[, given as wall clock times of the installation.
+func (s *Server) handleSiteData(w http.ResponseWriter, r *http.Request, installationId string, meterType climkit.MeterType) {
+	inst, location, ok := s.installationLocation(w, installationId)
+	if !ok {
+		return
+	}
+	start, end, ok := readWindow(w, r, location)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	samples := samplesBetween(inst.series[meterType], start, end)
+	s.mu.Unlock()
+
+	rows := make([]map[string]interface{}, 0, len(samples))
+	for _, sample := range samples {
+		rows = append(rows, sample.row(location, climkit.ClimkitTimeFormat))
+	}
+	writeJSON(w, rows)
+}
+
+// handleMeterData returns the columns of one meter of the site data, within the window [t_s, t_e[.
+func (s *Server) handleMeterData(w http.ResponseWriter, r *http.Request, installationId string, meterId string) {
+	inst, location, ok := s.installationLocation(w, installationId)
+	if !ok {
+		return
+	}
+	start, end, ok := readWindow(w, r, location)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	meter, found := climkit.FindMeter(inst.meters, meterId)
+	var samples []Sample
+	if found {
+		samples = samplesBetween(inst.series[climkit.MeterType(meter.Type)], start, end)
+	}
+	s.mu.Unlock()
+	if !found {
+		writeError(w, http.StatusNotFound, "unknown meter "+meterId)
+		return
+	}
+
+	rows := make([]map[string]interface{}, 0, len(samples))
+	for _, sample := range samples {
+		rows = append(rows, sample.meterRow(meterId, location))
+	}
+	writeJSON(w, rows)
+}
+
+// handleRawData returns at most maxRawReadings readings of a meter, starting at t_s.
+func (s *Server) handleRawData(w http.ResponseWriter, r *http.Request, installationId string, meterId string) {
+	inst, location, ok := s.installationLocation(w, installationId)
+	if !ok {
+		return
+	}
+	var request climkit.RawTimeSeriesRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	start, err := parseWallClock(request.StartTime, location)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid t_s: "+err.Error())
+		return
+	}
+
+	rows := []map[string]interface{}{}
+	s.mu.Lock()
+	for _, reading := range inst.raw[meterId] {
+		if reading.Timestamp.Before(start) || len(rows) == maxRawReadings {
+			continue
+		}
+		rows = append(rows, map[string]interface{}{
+			"timestamp": reading.Timestamp.In(location).Format(climkit.ClimkitMeterTimeFormat),
+			"value":     reading.Value,
+		})
+	}
+	s.mu.Unlock()
+	writeJSON(w, rows)
+}
+
+func (s *Server) installationLocation(w http.ResponseWriter, installationId string) (*installation, *time.Location, bool) {
+	s.mu.Lock()
+	inst, found := s.installations[installationId]
+	var timezone string
+	if found {
+		timezone = inst.info.Timezone
+	}
+	s.mu.Unlock()
+	if !found {
+		writeError(w, http.StatusNotFound, "unknown installation "+installationId)
+		return nil, nil, false
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "invalid time zone "+timezone)
+		return nil, nil, false
+	}
+	return inst, location, true
+}
+
+func readWindow(w http.ResponseWriter, r *http.Request, location *time.Location) (time.Time, time.Time, bool) {
+	var request climkit.TimeSeriesRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return time.Time{}, time.Time{}, false
+	}
+	start, err := parseWallClock(request.StartTime, location)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid t_s: "+err.Error())
+		return time.Time{}, time.Time{}, false
+	}
+	end, err := parseWallClock(request.EndTime, location)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid t_e: "+err.Error())
+		return time.Time{}, time.Time{}, false
+	}
+	return start, end, true
+}
+
+// parseWallClock parses a time of a request, with a space or a 'T' separator and without offset. A wall clock time
+// repeated when the clocks go back is read as its first occurrence, as the client resolves the returned timestamps.
+func parseWallClock(value string, location *time.Location) (time.Time, error) {
+	layout := climkit.ClimkitMeterTimeFormat
+	parsed, err := time.ParseInLocation(layout, strings.Replace(value, " ", "T", 1), location)
+	if err != nil {
+		return parsed, err
+	}
+	// the daylight saving changes are of one hour or less.
+	for _, shift := range []time.Duration{time.Hour, 30 * time.Minute} {
+		if earlier := parsed.Add(-shift); earlier.Format(layout) == parsed.Format(layout) {
+			return earlier, nil
+		}
+	}
+	return parsed, nil
+}
+
+func matchEndpoint(pattern string, endpoint string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, _ := path.Match(pattern, endpoint)
+	return matched
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
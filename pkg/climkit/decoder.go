@@ -0,0 +1,280 @@
+package climkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DecodeWarning reports a field of an API response that could not be decoded. The field is left to zero.
+type DecodeWarning struct {
+	Field string
+	Value string
+	Err   error
+}
+
+func (w DecodeWarning) String() string {
+	return fmt.Sprintf("%s=%s: %v", w.Field, w.Value, w.Err)
+}
+
+// jsonRow is one object of a time series response. It tracks the fields consumed by the decoding, so that the unknown
+// ones can be kept, and collects the warnings instead of failing.
+type jsonRow struct {
+	fields   map[string]json.RawMessage
+	used     map[string]bool
+	warnings []DecodeWarning
+}
+
+func newJsonRow(fields map[string]json.RawMessage) *jsonRow {
+	return &jsonRow{
+		fields: fields,
+		used:   make(map[string]bool),
+	}
+}
+
+func (r *jsonRow) warn(field string, value json.RawMessage, err error) {
+	r.warnings = append(r.warnings, DecodeWarning{Field: field, Value: string(value), Err: err})
+}
+
+// float returns the value of a numeric field, given either as a JSON number or as a numeric string. It returns false
+// if the field is missing, null or cannot be decoded.
+func (r *jsonRow) float(field string) (float64, bool) {
+	raw, found := r.fields[field]
+	r.used[field] = true
+	if !found || isNull(raw) {
+		return 0, false
+	}
+	value, ok := parseNumber(raw)
+	if !ok {
+		r.warn(field, raw, fmt.Errorf("not a number"))
+	}
+	return value, ok
+}
+
+// setFloat sets target to the value of the field, if present.
+func (r *jsonRow) setFloat(field string, target *float64) {
+	if value, ok := r.float(field); ok {
+		*target = value
+	}
+}
+
+// timestamp returns the value of a time field. The API returns ISO8601 timestamps, with a space instead of the 'T'
+// and with or without offset. Without offset, the time is a wall clock time of the installation time zone.
+func (r *jsonRow) timestamp(field string, resolver *timeResolver) (time.Time, bool) {
+	raw, found := r.fields[field]
+	r.used[field] = true
+	if !found || isNull(raw) {
+		r.warn(field, raw, fmt.Errorf("missing timestamp"))
+		return time.Time{}, false
+	}
+	var str string
+	if err := json.Unmarshal(raw, &str); err != nil {
+		r.warn(field, raw, fmt.Errorf("not a string"))
+		return time.Time{}, false
+	}
+	str = strings.Replace(strings.TrimSpace(str), " ", "T", 1) // fix timestamp format to ISO8601
+	if parsed, err := time.Parse(time.RFC3339, str); err == nil {
+		parsed = parsed.In(resolver.location)
+		resolver.observe(parsed)
+		return parsed, true
+	}
+	wall, err := time.Parse(ClimkitMeterTimeFormat, str)
+	if err != nil {
+		r.warn(field, raw, err)
+		return time.Time{}, false
+	}
+	parsed, err := resolver.resolve(wall)
+	if err != nil {
+		r.warn(field, raw, err)
+	}
+	return parsed, true
+}
+
+// unusedFields returns the fields not consumed by the decoding, sorted.
+func (r *jsonRow) unusedFields() []string {
+	var unused []string
+	for field := range r.fields {
+		if !r.used[field] {
+			unused = append(unused, field)
+		}
+	}
+	sort.Strings(unused)
+	return unused
+}
+
+// parseNumber decodes a JSON number or a numeric string.
+func parseNumber(raw json.RawMessage) (float64, bool) {
+	if isNull(raw) {
+		return 0, false
+	}
+	var value float64
+	if err := json.Unmarshal(raw, &value); err == nil {
+		return value, true
+	}
+	var str string
+	if err := json.Unmarshal(raw, &str); err != nil {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	return value, err == nil
+}
+
+func isNull(raw json.RawMessage) bool {
+	return len(raw) == 0 || string(raw) == "null"
+}
+
+// decodeMeterData decodes the rows of the site data of one meter type, in the order returned by the API. Rows without a
+// valid timestamp are skipped.
+func decodeMeterData(rows []map[string]json.RawMessage, meters []MeterInfo, meterType MeterType, resolver *timeResolver) ([]MeterData, []DecodeWarning) {
+	var meterDataArray []MeterData
+	var warnings []DecodeWarning
+
+	for _, fields := range rows {
+		row := newJsonRow(fields)
+		timestamp, ok := row.timestamp("timestamp", resolver)
+		if ok {
+			meterData := MeterData{
+				Type:      meterType,
+				Timestamp: timestamp,
+			}
+			switch meterType {
+			case Electricity:
+				decodeElectricityData(row, meters, &meterData)
+			default:
+				decodeConsumptionData(row, meters, &meterData)
+			}
+			decodeUnknownColumns(row, &meterData)
+			meterDataArray = append(meterDataArray, meterData)
+		}
+		warnings = append(warnings, row.warnings...)
+	}
+
+	timestamps := make([]time.Time, len(meterDataArray))
+	for i := range meterDataArray {
+		timestamps[i] = meterDataArray[i].Timestamp
+	}
+	for i, interval := range sampleIntervals(timestamps) {
+		meterDataArray[i].Interval = interval
+	}
+
+	return meterDataArray, warnings
+}
+
+func decodeElectricityData(row *jsonRow, meters []MeterInfo, meterData *MeterData) {
+	row.setFloat("conso_total", &meterData.ConsoTotal)
+	row.setFloat("from_ext", &meterData.FromExt)
+	row.setFloat("prod_total", &meterData.ProdTotal)
+	row.setFloat("self", &meterData.Self)
+	row.setFloat("storage_charging_total", &meterData.StorageChargingTotal)
+	row.setFloat("storage_discharging_total", &meterData.StorageDischargingTotal)
+	row.setFloat("to_ext", &meterData.ToExt)
+
+	for _, meter := range meters {
+		meterData.Meters = append(meterData.Meters, decodeMeterColumns(row, meter.Id, "_"+meter.Id))
+	}
+}
+
+// decodeConsumptionData decodes heating (kWh), water (m³) and charge point (kWh and sessions) series, which only
+// report a total per meter and for the whole installation.
+func decodeConsumptionData(row *jsonRow, meters []MeterInfo, meterData *MeterData) {
+	row.setFloat("total", &meterData.ConsoTotal)
+
+	for _, meter := range meters {
+		meterData.Meters = append(meterData.Meters, decodeMeterColumns(row, meter.Id, "_"+meter.Id))
+	}
+}
+
+// decodeMeterColumns decodes the values of one meter, whose column names end with the given suffix.
+func decodeMeterColumns(row *jsonRow, meterId string, suffix string) MeterDataItem {
+	item := MeterDataItem{
+		MeterId: meterId,
+	}
+	row.setFloat("ext"+suffix, &item.Ext)
+	row.setFloat("self"+suffix, &item.Self)
+	row.setFloat("total"+suffix, &item.Total)
+	if sessions, ok := row.float("sessions" + suffix); ok {
+		item.Sessions = int(sessions)
+	}
+	return item
+}
+
+// decodeUnknownColumns keeps the numeric columns that were not decoded in Extra. The columns of meters absent from the
+// meters info stay there too: a column sharing the prefix of the meter columns may as well be an aggregate, and the
+// callers only know the meters of the meters info.
+func decodeUnknownColumns(row *jsonRow, meterData *MeterData) {
+	for _, field := range row.unusedFields() {
+		if value, ok := parseNumber(row.fields[field]); ok {
+			if meterData.Extra == nil {
+				meterData.Extra = make(map[string]float64)
+			}
+			meterData.Extra[field] = value
+		}
+	}
+}
+
+// decodeSingleMeterData decodes the time series of one meter. The values are either reported as on the site data
+// (suffixed by the meter id) or without suffix.
+func decodeSingleMeterData(rows []map[string]json.RawMessage, meterId string, resolver *timeResolver) ([]SingleMeterData, []DecodeWarning) {
+	var meterDataArray []SingleMeterData
+	var warnings []DecodeWarning
+
+	for _, fields := range rows {
+		row := newJsonRow(fields)
+		timestamp, ok := row.timestamp("timestamp", resolver)
+		if ok {
+			item := decodeMeterColumns(row, meterId, "")
+			suffixed := decodeMeterColumns(row, meterId, "_"+meterId)
+			if _, found := fields["ext_"+meterId]; found {
+				item.Ext = suffixed.Ext
+			}
+			if _, found := fields["self_"+meterId]; found {
+				item.Self = suffixed.Self
+			}
+			if _, found := fields["total_"+meterId]; found {
+				item.Total = suffixed.Total
+			}
+			if _, found := fields["sessions_"+meterId]; found {
+				item.Sessions = suffixed.Sessions
+			}
+			meterDataArray = append(meterDataArray, SingleMeterData{
+				MeterDataItem: item,
+				Timestamp:     timestamp,
+			})
+		}
+		warnings = append(warnings, row.warnings...)
+	}
+
+	timestamps := make([]time.Time, len(meterDataArray))
+	for i := range meterDataArray {
+		timestamps[i] = meterDataArray[i].Timestamp
+	}
+	for i, interval := range sampleIntervals(timestamps) {
+		meterDataArray[i].Interval = interval
+	}
+
+	return meterDataArray, warnings
+}
+
+func decodeRawReadings(rows []map[string]json.RawMessage, meterId string, resolver *timeResolver) ([]RawReading, []DecodeWarning) {
+	var readings []RawReading
+	var warnings []DecodeWarning
+
+	for _, fields := range rows {
+		row := newJsonRow(fields)
+		timestamp, ok := row.timestamp("timestamp", resolver)
+		if ok {
+			reading := RawReading{
+				MeterId:   meterId,
+				Timestamp: timestamp,
+			}
+			row.setFloat("value", &reading.Value)
+			readings = append(readings, reading)
+		}
+		warnings = append(warnings, row.warnings...)
+	}
+
+	return readings, warnings
+}
@@ -0,0 +1,58 @@
+package climkit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func decodeRows(t *testing.T, content string) []map[string]json.RawMessage {
+	t.Helper()
+	var rows []map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(content), &rows); err != nil {
+		t.Fatalf("invalid rows: %v", err)
+	}
+	return rows
+}
+
+func TestDecodeMeterDataUnknownColumns(t *testing.T) {
+	rows := decodeRows(t, `[
+		{"timestamp": "2024-05-01 12:00:00", "prod_total": 1.5, "total_m-1": 0.5, "total_m-9": 0.25, "total_grid": 2, "note": "n/a"},
+		{"timestamp": "2024-05-01 12:15:00", "prod_total": "1.75", "total_m-1": 0.75, "self_m-9": 0.1, "total_grid": 3}
+	]`)
+	meters := []MeterInfo{{Id: "m-1", Type: string(Electricity)}}
+	resolver := newTimeResolver(time.UTC, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	data, warnings := decodeMeterData(rows, meters, Electricity, resolver)
+	if len(warnings) != 0 {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+	if len(data) != 2 {
+		t.Fatalf("got %d samples, want 2", len(data))
+	}
+	want := []struct {
+		prodTotal float64
+		total     float64
+		extra     map[string]float64
+	}{
+		{1.5, 0.5, map[string]float64{"total_m-9": 0.25, "total_grid": 2}},
+		{1.75, 0.75, map[string]float64{"self_m-9": 0.1, "total_grid": 3}},
+	}
+	for i, sample := range data {
+		if sample.ProdTotal != want[i].prodTotal || sample.Interval != 15*time.Minute {
+			t.Errorf("sample %d: got %+v", i, sample)
+		}
+		// only the meters of the meters info are decoded, the other columns may be aggregates.
+		if len(sample.Meters) != 1 || sample.Meters[0].MeterId != "m-1" || sample.Meters[0].Total != want[i].total {
+			t.Errorf("sample %d: got meters %+v, want m-1 only", i, sample.Meters)
+		}
+		if len(sample.Extra) != len(want[i].extra) {
+			t.Errorf("sample %d: got extra %v, want %v", i, sample.Extra, want[i].extra)
+		}
+		for field, value := range want[i].extra {
+			if got, found := sample.Extra[field]; !found || got != value {
+				t.Errorf("sample %d: got %s=%v, want %v", i, field, got, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package climkit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxErrorMessageLength bounds the message kept from a non JSON error body.
+const maxErrorMessageLength = 200
+
+// ErrFiltered is returned when requesting an installation or a meter excluded by the Filter option.
+var ErrFiltered = errors.New("filtered out by the configuration")
+
+// ErrNoRecording is returned in replay mode when no recording matches the request.
+var ErrNoRecording = errors.New("no recording for the request")
+
+// APIError is returned when the Climkit API answers with a non successful status.
+type APIError struct {
+	// Endpoint is the path of the API called, relative to the ApiUrl.
+	Endpoint   string
+	StatusCode int
+	// Message is the error message decoded from the response body.
+	Message string
+	// RetryAfter is the delay requested by the API in the Retry-After header, zero if absent.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("climkit API error on %s: %d %s: %s", e.Endpoint, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// IsUnauthorized returns true if the API rejected the credentials or the token, after the interceptor failed to
+// refresh it.
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized)
+}
+
+// IsForbidden returns true if the account has no access to the requested installation or meter.
+func IsForbidden(err error) bool {
+	return hasStatus(err, http.StatusForbidden)
+}
+
+// IsRateLimited returns true if the API asked to slow down.
+func IsRateLimited(err error) bool {
+	return hasStatus(err, http.StatusTooManyRequests)
+}
+
+// IsNotFound returns true if the requested installation, meter or resource does not exist or is not accessible.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsServerError returns true if the API failed with a 5xx status.
+func IsServerError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode >= 500
+}
+
+// RetryAfter returns the delay requested by the API before retrying, zero if the error carries no retry hint.
+func RetryAfter(err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.RetryAfter
+	}
+	return 0
+}
+
+func hasStatus(err error, statusCodes ...int) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	for _, statusCode := range statusCodes {
+		if apiErr.StatusCode == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+func newAPIError(endpoint string, resp *http.Response, body []byte) *APIError {
+	return &APIError{
+		Endpoint:   endpoint,
+		StatusCode: resp.StatusCode,
+		Message:    decodeErrorMessage(body),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// decodeErrorMessage extracts the message of an error body, which is usually a JSON object with a message field.
+func decodeErrorMessage(body []byte) string {
+	var jsonBody map[string]interface{}
+	if err := json.Unmarshal(body, &jsonBody); err == nil {
+		for _, key := range []string{"message", "msg", "error", "detail"} {
+			if message, ok := jsonBody[key].(string); ok && message != "" {
+				return message
+			}
+		}
+	}
+	message := strings.TrimSpace(string(body))
+	if len(message) > maxErrorMessageLength {
+		message = message[:maxErrorMessageLength] + "..."
+	}
+	return message
+}
+
+// parseRetryAfter parses the Retry-After header, given either in seconds or as an HTTP date.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(strings.TrimSpace(header)); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
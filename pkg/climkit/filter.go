@@ -0,0 +1,103 @@
+package climkit
+
+import (
+	"fmt"
+	"path"
+)
+
+// Patterns is an allow-list and a deny-list of glob patterns, with the syntax of path.Match ("*", "?", "[a-z]").
+type Patterns struct {
+	// Include lists the allowed values, empty allows everything.
+	Include []string
+	// Exclude lists the denied values, it takes precedence over Include.
+	Exclude []string
+}
+
+// Match returns true if the value is allowed by the patterns.
+func (p Patterns) Match(value string) bool {
+	if matchAny(p.Exclude, value) {
+		return false
+	}
+	return len(p.Include) == 0 || matchAny(p.Include, value)
+}
+
+func (p Patterns) validate() error {
+	for _, pattern := range append(append([]string{}, p.Include...), p.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter selects the installations and meters the client works with. The filtered entities are never returned by
+// the client, and requesting them fails with ErrFiltered.
+type Filter struct {
+	Installations Patterns
+	SiteRefs      Patterns
+	Meters        Patterns
+	MeterTypes    Patterns
+}
+
+func (f *Filter) validate() error {
+	for name, patterns := range map[string]Patterns{
+		"installations": f.Installations,
+		"site refs":     f.SiteRefs,
+		"meters":        f.Meters,
+		"meter types":   f.MeterTypes,
+	} {
+		if err := patterns.validate(); err != nil {
+			return fmt.Errorf("%s filter: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// filtersSiteRefs returns true if the site ref filter is set, which requires the installation info to select the
+// installations.
+func (f *Filter) filtersSiteRefs() bool {
+	return len(f.SiteRefs.Include) > 0 || len(f.SiteRefs.Exclude) > 0
+}
+
+// filtersMeterTypes returns true if the meter type filter is set, which requires the meters info to select a meter by
+// its id.
+func (f *Filter) filtersMeterTypes() bool {
+	return len(f.MeterTypes.Include) > 0 || len(f.MeterTypes.Exclude) > 0
+}
+
+// FilterMeters returns the meters allowed by their id and their type.
+func (f *Filter) FilterMeters(meters []MeterInfo) []MeterInfo {
+	var filtered []MeterInfo
+	for _, meter := range meters {
+		if f.Meters.Match(meter.Id) && f.MeterTypes.Match(meter.Type) {
+			filtered = append(filtered, meter)
+		}
+	}
+	return filtered
+}
+
+func (f *Filter) checkInstallation(installationId string) error {
+	if !f.Installations.Match(installationId) {
+		return fmt.Errorf("installation %s: %w", installationId, ErrFiltered)
+	}
+	return nil
+}
+
+func (f *Filter) checkMeter(installationId string, meterId string) error {
+	if err := f.checkInstallation(installationId); err != nil {
+		return err
+	}
+	if !f.Meters.Match(meterId) {
+		return fmt.Errorf("meter %s: %w", meterId, ErrFiltered)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package climkit_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gaetancollaud/climkit/pkg/climkit"
+)
+
+func TestFilterMeterTypesOfSingleMeterRequests(t *testing.T) {
+	server := newTestServer(t)
+	server.AddMeter("inst-1", climkit.MeterInfo{Id: "h-1", Type: string(climkit.Heating)})
+	client := newTestClient(t, server.ClientOptions().SetFilter(climkit.Filter{
+		MeterTypes: climkit.Patterns{Exclude: []string{string(climkit.Heating)}},
+	}))
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		meterId  string
+		filtered bool
+	}{
+		{"allowed type", "m-1", false},
+		{"excluded type", "h-1", true},
+		{"unknown meter", "x-1", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := map[string]func() error{
+				"single meter data": func() error {
+					_, err := client.GetSingleMeterData("inst-1", test.meterId, start, start.Add(time.Hour))
+					return err
+				},
+				"raw data": func() error {
+					_, err := client.GetMeterRawData("inst-1", test.meterId, start)
+					return err
+				},
+			}
+			for name, request := range requests {
+				if err := request(); errors.Is(err, climkit.ErrFiltered) != test.filtered {
+					t.Errorf("%s: got %v, want filtered %t", name, err, test.filtered)
+				}
+			}
+			if test.filtered {
+				if count := server.RequestCount("v1/meter_data*/inst-1/" + test.meterId); count != 0 {
+					t.Errorf("got %d requests of the filtered meter", count)
+				}
+			}
+		})
+	}
+}
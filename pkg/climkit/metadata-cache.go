@@ -0,0 +1,233 @@
+package climkit
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// cacheEntry is a cached API response and the time it was received.
+type cacheEntry[T any] struct {
+	Value     T         `json:"value"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// metadataSnapshot is the content of the cache, as persisted on disk.
+type metadataSnapshot struct {
+	InstallationIds *cacheEntry[[]string]                   `json:"installation_ids,omitempty"`
+	Installations   map[string]cacheEntry[InstallationInfo] `json:"installations"`
+	Meters          map[string]cacheEntry[[]MeterInfo]      `json:"meters"`
+}
+
+// MetadataCache keeps the installations and meters returned by the API, which rarely change. Fresh entries are used
+// instead of a request. Expired ones are still returned when the API is unavailable, so that a restart during an
+// outage comes up with the last known installations and meters.
+type MetadataCache struct {
+	ttl  time.Duration
+	file string
+	log  zerolog.Logger
+
+	mu       sync.Mutex
+	snapshot metadataSnapshot
+}
+
+// NewMetadataCache creates a cache whose entries are fresh for ttl (zero means always refreshed, the entries are only
+// used as fallback). If file is set, the cache is loaded from and saved to it.
+func NewMetadataCache(logger zerolog.Logger, ttl time.Duration, file string) *MetadataCache {
+	cache := &MetadataCache{
+		ttl:  ttl,
+		file: file,
+		log:  logger,
+		snapshot: metadataSnapshot{
+			Installations: make(map[string]cacheEntry[InstallationInfo]),
+			Meters:        make(map[string]cacheEntry[[]MeterInfo]),
+		},
+	}
+	if file != "" {
+		cache.load()
+	}
+	return cache
+}
+
+type freshMetadataContextKey struct{}
+
+// WithFreshMetadata returns a context whose requests of installations and meters query the API even if they are
+// cached, e.g. to rediscover them. The responses update the cache, which is still used if the API fails.
+func WithFreshMetadata(ctx context.Context) context.Context {
+	return context.WithValue(ctx, freshMetadataContextKey{}, true)
+}
+
+func freshMetadataFromContext(ctx context.Context) bool {
+	fresh, _ := ctx.Value(freshMetadataContextKey{}).(bool)
+	return fresh
+}
+
+// Invalidate removes all the entries, the next calls query the API.
+func (m *MetadataCache) Invalidate() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshot.InstallationIds = nil
+	m.snapshot.Installations = make(map[string]cacheEntry[InstallationInfo])
+	m.snapshot.Meters = make(map[string]cacheEntry[[]MeterInfo])
+	m.save()
+}
+
+// InvalidateInstallation removes the info and the meters of one installation.
+func (m *MetadataCache) InvalidateInstallation(installationId string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.snapshot.Installations, installationId)
+	delete(m.snapshot.Meters, installationId)
+	m.save()
+}
+
+func (m *MetadataCache) isFresh(fetchedAt time.Time) bool {
+	return m.ttl > 0 && time.Since(fetchedAt) < m.ttl
+}
+
+func (m *MetadataCache) load() {
+	content, err := ioutil.ReadFile(m.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			m.log.Warn().Err(err).Str("file", m.file).Msg("Unable to read the metadata cache")
+		}
+		return
+	}
+	var snapshot metadataSnapshot
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		m.log.Warn().Err(err).Str("file", m.file).Msg("Ignoring invalid metadata cache")
+		return
+	}
+	if snapshot.Installations != nil {
+		m.snapshot.Installations = snapshot.Installations
+	}
+	if snapshot.Meters != nil {
+		m.snapshot.Meters = snapshot.Meters
+	}
+	m.snapshot.InstallationIds = snapshot.InstallationIds
+	m.log.Info().Str("file", m.file).Int("installations", len(m.snapshot.Installations)).Msg("Metadata cache loaded")
+}
+
+// save writes the cache to its file, if any. The mutex must be held.
+func (m *MetadataCache) save() {
+	if m.file == "" {
+		return
+	}
+	content, err := json.Marshal(m.snapshot)
+	if err != nil {
+		m.log.Error().Err(err).Msg("Unable to serialize the metadata cache")
+		return
+	}
+	// write then rename, so that a crash never leaves a truncated file.
+	tmp, err := ioutil.TempFile(filepath.Dir(m.file), filepath.Base(m.file)+".*")
+	if err != nil {
+		m.log.Error().Err(err).Str("file", m.file).Msg("Unable to save the metadata cache")
+		return
+	}
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), m.file)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		m.log.Error().Err(err).Str("file", m.file).Msg("Unable to save the metadata cache")
+	}
+}
+
+// cached returns the value of the entry if it is fresh and the context does not require fresh metadata, or requests
+// it and updates the entry. If the request fails with an error that may be transient, the expired entry is returned
+// instead.
+func cached[T any](ctx context.Context, m *MetadataCache, methodName string,
+	get func(*metadataSnapshot) (cacheEntry[T], bool),
+	set func(*metadataSnapshot, *cacheEntry[T]),
+	request func() (T, error)) (T, error) {
+	if m == nil {
+		return request()
+	}
+
+	m.mu.Lock()
+	entry, found := get(&m.snapshot)
+	m.mu.Unlock()
+	if found && m.isFresh(entry.FetchedAt) && !freshMetadataFromContext(ctx) {
+		return entry.Value, nil
+	}
+
+	value, err := request()
+	if err == nil {
+		m.mu.Lock()
+		set(&m.snapshot, &cacheEntry[T]{Value: value, FetchedAt: time.Now()})
+		m.save()
+		m.mu.Unlock()
+		return value, nil
+	}
+	if IsNotFound(err) || IsForbidden(err) {
+		m.mu.Lock()
+		set(&m.snapshot, nil)
+		m.save()
+		m.mu.Unlock()
+		return value, err
+	}
+	if !found || ctx.Err() != nil || IsUnauthorized(err) {
+		return value, err
+	}
+	m.log.Warn().Err(err).Str("methodName", methodName).Time("fetchedAt", entry.FetchedAt).Msg("Climkit unavailable, using cached value")
+	return entry.Value, nil
+}
+
+func (m *MetadataCache) installationIds(ctx context.Context, request func() ([]string, error)) ([]string, error) {
+	return cached(ctx, m, "installations",
+		func(s *metadataSnapshot) (cacheEntry[[]string], bool) {
+			if s.InstallationIds == nil {
+				return cacheEntry[[]string]{}, false
+			}
+			return *s.InstallationIds, true
+		},
+		func(s *metadataSnapshot, entry *cacheEntry[[]string]) {
+			s.InstallationIds = entry
+		}, request)
+}
+
+func (m *MetadataCache) installationInfo(ctx context.Context, installationId string, request func() (InstallationInfo, error)) (InstallationInfo, error) {
+	return cached(ctx, m, "installation info",
+		func(s *metadataSnapshot) (cacheEntry[InstallationInfo], bool) {
+			entry, found := s.Installations[installationId]
+			return entry, found
+		},
+		func(s *metadataSnapshot, entry *cacheEntry[InstallationInfo]) {
+			if entry == nil {
+				delete(s.Installations, installationId)
+			} else {
+				s.Installations[installationId] = *entry
+			}
+		}, request)
+}
+
+func (m *MetadataCache) metersInfos(ctx context.Context, installationId string, request func() ([]MeterInfo, error)) ([]MeterInfo, error) {
+	return cached(ctx, m, "meters info",
+		func(s *metadataSnapshot) (cacheEntry[[]MeterInfo], bool) {
+			entry, found := s.Meters[installationId]
+			return entry, found
+		},
+		func(s *metadataSnapshot, entry *cacheEntry[[]MeterInfo]) {
+			if entry == nil {
+				delete(s.Meters, installationId)
+			} else {
+				s.Meters[installationId] = *entry
+			}
+		}, request)
+}
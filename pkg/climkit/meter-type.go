@@ -0,0 +1,72 @@
+package climkit
+
+type MeterType string
+
+const (
+	Electricity MeterType = "electricity"
+	Heating     MeterType = "heating"
+	ColdWater   MeterType = "cold_water"
+	HotWater    MeterType = "hot_water"
+	ChargePoint MeterType = "charge_point"
+)
+
+// Unit returns the unit in which the API reports the values of this meter type.
+func (t MeterType) Unit() string {
+	if t.IsVolume() {
+		return UnitM3
+	}
+	return UnitKWh
+}
+
+// IsVolume returns true if the values of this meter type are volumes (m³) and not energies (kWh).
+func (t MeterType) IsVolume() bool {
+	return t == ColdWater || t == HotWater
+}
+
+var MeterTypes = []MeterType{Electricity, Heating, ColdWater, HotWater, ChargePoint}
+
+// IsKnown returns true if the meter type is one of the MeterTypes supported by this client.
+func (t MeterType) IsKnown() bool {
+	for _, known := range MeterTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// GetMeterTypes returns the distinct known meter types present in the given meters,
+// in the order they first appear. Unknown types are skipped.
+func GetMeterTypes(meters []MeterInfo) []MeterType {
+	var types []MeterType
+	seen := make(map[MeterType]bool)
+	for _, meter := range meters {
+		meterType := MeterType(meter.Type)
+		if meterType.IsKnown() && !seen[meterType] {
+			seen[meterType] = true
+			types = append(types, meterType)
+		}
+	}
+	return types
+}
+
+// FilterMetersByType returns only the meters of the given type.
+func FilterMetersByType(meters []MeterInfo, meterType MeterType) []MeterInfo {
+	var filtered []MeterInfo
+	for _, meter := range meters {
+		if MeterType(meter.Type) == meterType {
+			filtered = append(filtered, meter)
+		}
+	}
+	return filtered
+}
+
+// FindMeter returns the meter with the given id, if present in the meters.
+func FindMeter(meters []MeterInfo, meterId string) (MeterInfo, bool) {
+	for _, meter := range meters {
+		if meter.Id == meterId {
+			return meter, true
+		}
+	}
+	return MeterInfo{}, false
+}
@@ -10,14 +10,54 @@ type ClientOptions struct {
 	Username     string
 	Password     string
 	PollInterval time.Duration
+	// RequestTimeout is the deadline of each attempt of an API call, including its wait for the rate limiter, and of the
+	// token refresh. Zero means no deadline.
+	RequestTimeout time.Duration
+	// RetryMaxAttempts caps the number of attempts of a request failing with a transient error. 1 disables retries.
+	RetryMaxAttempts int
+	// RetryMinBackoff is the wait before the first retry, doubled at each attempt up to RetryMaxBackoff.
+	RetryMinBackoff time.Duration
+	RetryMaxBackoff time.Duration
+	// RateLimit is the average number of requests per second allowed to the API, shared by all the callers of the
+	// client. Zero disables the limit.
+	RateLimit float64
+	// RateBurst is the number of requests that can be sent at once before the RateLimit applies.
+	RateBurst int
+	// ChunkInterval is the longest time range fetched in one request, longer ranges are split. Zero disables the split.
+	ChunkInterval time.Duration
+	// ChunkConcurrency is the number of chunks of a range fetched at once.
+	ChunkConcurrency int
+	// CacheTTL is the time the installations and meters are cached. Zero disables the cache, unless CacheFile is set.
+	CacheTTL time.Duration
+	// CacheFile persists the cache, so that the last known installations and meters survive a restart during an API
+	// outage. Empty keeps the cache in memory.
+	CacheFile string
+	// Filter selects the installations and meters, the other ones are never fetched.
+	Filter Filter
+	TLS    TLSOptions
+	// Recording saves the API exchanges to files, or replays them instead of calling the API.
+	Recording RecordingOptions
+	// Label identifies the account in the logs when several clients are used.
+	Label string
 }
 
 func NewClientOptions() *ClientOptions {
 	return &ClientOptions{
-		ApiUrl:       "https://api.climkit.io/api/v1/",
-		Username:     "",
-		Password:     "",
-		PollInterval: time.Minute * 5,
+		ApiUrl:           "https://api.climkit.io/api/v1/",
+		Username:         "",
+		Password:         "",
+		PollInterval:     time.Minute * 5,
+		RequestTimeout:   time.Second * 30,
+		RetryMaxAttempts: 5,
+		RetryMinBackoff:  time.Second,
+		RetryMaxBackoff:  time.Minute,
+		RateLimit:        0.5,
+		RateBurst:        5,
+		ChunkInterval:    time.Hour * 24 * 30,
+		ChunkConcurrency: 1,
+		TLS: TLSOptions{
+			MinVersion: "1.2",
+		},
 	}
 }
 
@@ -36,7 +76,61 @@ func (o *ClientOptions) SetPassword(p string) *ClientOptions {
 	return o
 }
 
+func (o *ClientOptions) SetLabel(label string) *ClientOptions {
+	o.Label = label
+	return o
+}
+
 func (o *ClientOptions) SetPollInterval(pollInterval time.Duration) *ClientOptions {
 	o.PollInterval = pollInterval
 	return o
 }
+
+func (o *ClientOptions) SetRequestTimeout(requestTimeout time.Duration) *ClientOptions {
+	o.RequestTimeout = requestTimeout
+	return o
+}
+
+func (o *ClientOptions) SetRetryMaxAttempts(retryMaxAttempts int) *ClientOptions {
+	o.RetryMaxAttempts = retryMaxAttempts
+	return o
+}
+
+func (o *ClientOptions) SetRetryBackoff(minBackoff time.Duration, maxBackoff time.Duration) *ClientOptions {
+	o.RetryMinBackoff = minBackoff
+	o.RetryMaxBackoff = maxBackoff
+	return o
+}
+
+func (o *ClientOptions) SetRateLimit(requestsPerSecond float64, burst int) *ClientOptions {
+	o.RateLimit = requestsPerSecond
+	o.RateBurst = burst
+	return o
+}
+
+func (o *ClientOptions) SetChunking(interval time.Duration, concurrency int) *ClientOptions {
+	o.ChunkInterval = interval
+	o.ChunkConcurrency = concurrency
+	return o
+}
+
+func (o *ClientOptions) SetCache(ttl time.Duration, file string) *ClientOptions {
+	o.CacheTTL = ttl
+	o.CacheFile = file
+	return o
+}
+
+func (o *ClientOptions) SetFilter(filter Filter) *ClientOptions {
+	o.Filter = filter
+	return o
+}
+
+func (o *ClientOptions) SetRecording(recording RecordingOptions) *ClientOptions {
+	o.Recording = recording
+	return o
+}
+
+func (o *ClientOptions) SetTLS(tlsOptions TLSOptions) *ClientOptions {
+	o.TLS = tlsOptions
+	return o
+}
@@ -0,0 +1,71 @@
+package climkit
+
+import "time"
+
+// Units of the quantities.
+const (
+	UnitKWh = "kWh"
+	UnitKW  = "kW"
+	UnitM3  = "m³"
+)
+
+// DefaultSampleInterval is the interval assumed for a series of a single point, the API samples every 15 minutes.
+const DefaultSampleInterval = 15 * time.Minute
+
+// Energy in kWh, consumed or produced during an interval.
+type Energy float64
+
+// AveragePower returns the average power during the interval in which the energy was measured.
+func (e Energy) AveragePower(interval time.Duration) Power {
+	if interval <= 0 {
+		return 0
+	}
+	return Power(float64(e) / interval.Hours())
+}
+
+// Power in kW.
+type Power float64
+
+// Volume in m³.
+type Volume float64
+
+// Quantity is a value reported by the API, typed according to its meter type: an energy and the derived average
+// power, or a volume for the water meters.
+type Quantity struct {
+	Energy Energy
+	Power  Power
+	Volume Volume
+	// IsVolume is true if the value is a Volume, Energy and Power are then zero.
+	IsVolume bool
+	// Interval is the length of the sample the value was measured on.
+	Interval time.Duration
+}
+
+// NewQuantity types a value of the given meter type measured during interval.
+func NewQuantity(meterType MeterType, value float64, interval time.Duration) Quantity {
+	if meterType.IsVolume() {
+		return Quantity{Volume: Volume(value), IsVolume: true, Interval: interval}
+	}
+	energy := Energy(value)
+	return Quantity{Energy: energy, Power: energy.AveragePower(interval), Interval: interval}
+}
+
+// sampleIntervals returns the length of each sample of an ordered series: the nominal spacing of the series, which is
+// the smallest time between two consecutive samples. A gap in the data, after missing samples, is not the length of
+// the sample that follows it. A series of a single sample gets the DefaultSampleInterval.
+func sampleIntervals(timestamps []time.Time) []time.Duration {
+	nominal := time.Duration(0)
+	for i := 1; i < len(timestamps); i++ {
+		if delta := timestamps[i].Sub(timestamps[i-1]); delta > 0 && (nominal == 0 || delta < nominal) {
+			nominal = delta
+		}
+	}
+	if nominal == 0 {
+		nominal = DefaultSampleInterval
+	}
+	intervals := make([]time.Duration, len(timestamps))
+	for i := range intervals {
+		intervals[i] = nominal
+	}
+	return intervals
+}
@@ -0,0 +1,53 @@
+package climkit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSampleIntervals(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minutes ...int) []time.Time {
+		timestamps := make([]time.Time, len(minutes))
+		for i, minute := range minutes {
+			timestamps[i] = start.Add(time.Duration(minute) * time.Minute)
+		}
+		return timestamps
+	}
+	tests := []struct {
+		name       string
+		timestamps []time.Time
+		want       time.Duration
+	}{
+		{"single sample", at(0), DefaultSampleInterval},
+		{"regular series", at(0, 15, 30, 45), 15 * time.Minute},
+		{"gap after missing samples", at(0, 15, 120, 135), 15 * time.Minute},
+		{"gap before the first samples", at(0, 105, 120), 15 * time.Minute},
+		{"hourly series", at(0, 60, 120), time.Hour},
+		{"duplicate timestamps", at(0, 0, 15), 15 * time.Minute},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			intervals := sampleIntervals(test.timestamps)
+			if len(intervals) != len(test.timestamps) {
+				t.Fatalf("got %d intervals, want %d", len(intervals), len(test.timestamps))
+			}
+			for i, interval := range intervals {
+				if interval != test.want {
+					t.Errorf("sample %d: got %s, want %s", i, interval, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewQuantity(t *testing.T) {
+	energy := NewQuantity(Electricity, 1.5, 15*time.Minute)
+	if energy.IsVolume || energy.Energy != 1.5 || energy.Power != 6 {
+		t.Errorf("got %+v, want 1.5 kWh and 6 kW", energy)
+	}
+	volume := NewQuantity(ColdWater, 0.2, 15*time.Minute)
+	if !volume.IsVolume || volume.Volume != 0.2 || volume.Power != 0 {
+		t.Errorf("got %+v, want 0.2 m³", volume)
+	}
+}
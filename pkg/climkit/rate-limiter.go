@@ -0,0 +1,171 @@
+package climkit
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Priority of a request for the rate limiter. While high priority requests are waiting for a token, low priority ones
+// are held back.
+type Priority int
+
+const (
+	// PriorityHigh is the default priority, used for the live polling.
+	PriorityHigh Priority = iota
+	// PriorityLow should be used for long running work, like historical backfills.
+	PriorityLow
+)
+
+// slowWaitThreshold is the wait above which the limiter logs at info level instead of debug.
+const slowWaitThreshold = time.Second
+
+type priorityContextKey struct{}
+
+// WithPriority returns a context whose requests are rate limited with the given priority.
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityContextKey{}, priority)
+}
+
+func priorityFromContext(ctx context.Context) Priority {
+	if priority, ok := ctx.Value(priorityContextKey{}).(Priority); ok {
+		return priority
+	}
+	return PriorityHigh
+}
+
+// RateLimiter is a token bucket shared by all the callers of a Client.
+type RateLimiter struct {
+	mu sync.Mutex
+	// rate is the number of tokens added per second.
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	waiting map[Priority]int
+	log     zerolog.Logger
+}
+
+// NewRateLimiter creates a limiter allowing requestsPerSecond on average and up to burst requests at once. It returns
+// nil, which disables the limit, if requestsPerSecond is not positive.
+func NewRateLimiter(logger zerolog.Logger, requestsPerSecond float64, burst int) *RateLimiter {
+	if requestsPerSecond <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:    requestsPerSecond,
+		burst:   float64(burst),
+		tokens:  float64(burst),
+		last:    time.Now(),
+		waiting: make(map[Priority]int),
+		log:     logger,
+	}
+}
+
+// Wait blocks until a token is available for the given priority or the context is cancelled. It returns the time
+// spent waiting.
+func (l *RateLimiter) Wait(ctx context.Context, priority Priority) (time.Duration, error) {
+	if l == nil {
+		return 0, nil
+	}
+	start := time.Now()
+	registered := false
+	defer func() {
+		if registered {
+			l.mu.Lock()
+			l.waiting[priority]--
+			l.mu.Unlock()
+		}
+	}()
+
+	for {
+		l.mu.Lock()
+		l.refill(time.Now())
+		if l.tokens >= 1 && !l.higherPriorityWaiting(priority) {
+			l.tokens--
+			l.mu.Unlock()
+			return time.Since(start), nil
+		}
+		if !registered {
+			l.waiting[priority]++
+			registered = true
+		}
+		wait := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		if wait <= 0 {
+			// a token is available but reserved for a higher priority, check again shortly.
+			wait = time.Duration(float64(time.Second) / l.rate)
+		}
+		l.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return time.Since(start), ctx.Err()
+		}
+	}
+}
+
+func (l *RateLimiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
+func (l *RateLimiter) higherPriorityWaiting(priority Priority) bool {
+	for other, count := range l.waiting {
+		if other < priority && count > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// logWait reports the time a request spent waiting for the limiter.
+func (l *RateLimiter) logWait(url string, priority Priority, wait time.Duration) {
+	if l == nil || wait <= 0 {
+		return
+	}
+	event := l.log.Debug()
+	if wait >= slowWaitThreshold {
+		event = l.log.Info()
+	}
+	event.Str("url", url).Int("priority", int(priority)).Dur("wait", wait).Msg("Rate limited request")
+}
+
+// RateLimitTransport takes a token of the limiter before sending each request, with the priority set on its context
+// by WithPriority. It sits below the RetryTransport and the Interceptor, so that the retries, the token requests and
+// the replays after a rejected token are limited too.
+type RateLimitTransport struct {
+	core    http.RoundTripper
+	limiter *RateLimiter
+}
+
+func NewRateLimitTransport(limiter *RateLimiter, core http.RoundTripper) *RateLimitTransport {
+	return &RateLimitTransport{
+		core:    core,
+		limiter: limiter,
+	}
+}
+
+func (t *RateLimitTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	priority := priorityFromContext(r.Context())
+	wait, err := t.limiter.Wait(r.Context(), priority)
+	t.limiter.logWait(r.URL.Path, priority, wait)
+	if err != nil {
+		if r.Body != nil {
+			_ = r.Body.Close()
+		}
+		return nil, err
+	}
+	return t.core.RoundTrip(r)
+}
@@ -0,0 +1,444 @@
+package climkit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+type RecordingMode string
+
+const (
+	// RecordingOff sends the requests to the API.
+	RecordingOff RecordingMode = ""
+	// RecordingRecord sends the requests to the API and saves each exchange to the recording directory.
+	RecordingRecord RecordingMode = "record"
+	// RecordingReplay answers the requests with the exchanges of the recording directory, without calling the API.
+	RecordingReplay RecordingMode = "replay"
+)
+
+// redactedValue replaces the secret and personal string values of the recordings.
+const redactedValue = "redacted"
+
+// redactedFields are the JSON fields removed from the recorded bodies: the credentials and tokens, and the personal
+// data of the installations. The values are replaced by a value of the same JSON type, so that the recordings can
+// still be decoded.
+var redactedFields = map[string]bool{
+	"username":      true,
+	"password":      true,
+	"access_token":  true,
+	"refresh_token": true,
+	"token":         true,
+	"name":          true,
+	"site_ref":      true,
+	"street_name":   true,
+	"street_number": true,
+	"city_name":     true,
+	"latitude":      true,
+	"longitude":     true,
+}
+
+// recordedHeaders are the only response headers kept in the recordings.
+var recordedHeaders = []string{"Content-Type", "Retry-After"}
+
+// windowFields are the fields of the time window of the data requests. The modules compute it from the current time,
+// the recordings are matched without it when no recording has the same window.
+var windowFields = []string{"t_s", "t_e"}
+
+// recordingFileName matches the end of the names of the recordings: the key of the request and its sequence.
+var recordingFileName = regexp.MustCompile(`-([0-9a-f]{12})-([0-9]+)\.json$`)
+
+// RecordingOptions configures the recording of the API exchanges, to reproduce a bug without the API.
+type RecordingOptions struct {
+	Mode RecordingMode
+	// Dir is the directory of the recordings, one JSON file per exchange.
+	Dir string
+}
+
+func (o *RecordingOptions) validate() error {
+	switch o.Mode {
+	case RecordingOff:
+		return nil
+	case RecordingRecord, RecordingReplay:
+		if o.Dir == "" {
+			return fmt.Errorf("the %s mode requires a recording directory", o.Mode)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown recording mode '%s'", o.Mode)
+	}
+}
+
+// Recording is one exchange with the API, as saved to a file.
+type Recording struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string `json:"method"`
+	// Path is relative to the ApiUrl, with the query.
+	Path string          `json:"path"`
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int                 `json:"status_code"`
+	Header     map[string][]string `json:"header,omitempty"`
+	// Body is set if the body is valid JSON, BodyText otherwise, so that malformed responses are replayed as is.
+	Body     json.RawMessage `json:"body,omitempty"`
+	BodyText string          `json:"body_text,omitempty"`
+}
+
+// key identifies the requests answered by the same recordings, whatever their time window.
+func (r RecordedRequest) key() string {
+	return requestKey(r.Method, r.Path, withoutWindow(r.Body))
+}
+
+// exactKey also includes the time window, to prefer the recordings of the same window.
+func (r RecordedRequest) exactKey() string {
+	return requestKey(r.Method, r.Path, r.Body)
+}
+
+func requestKey(method string, path string, body []byte) string {
+	sum := sha256.Sum256(append([]byte(method+" "+path+"\n"), body...))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// withoutWindow returns the body without the windowFields, unchanged if it is not a JSON object.
+func withoutWindow(body []byte) []byte {
+	var fields map[string]json.RawMessage
+	if len(body) == 0 || json.Unmarshal(body, &fields) != nil {
+		return body
+	}
+	for _, field := range windowFields {
+		delete(fields, field)
+	}
+	// the fields are sorted by Marshal.
+	stripped, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return stripped
+}
+
+// RecordTransport saves the sanitized exchanges with the API. It wraps the Interceptor, so that the token requests
+// and the Authorization headers are never seen.
+type RecordTransport struct {
+	core    http.RoundTripper
+	options ClientOptions
+	log     zerolog.Logger
+
+	mu sync.Mutex
+	// sequences counts the recordings of each request, repeated requests are replayed in the same order. They
+	// continue the recordings already in the directory, a new session appends to them.
+	sequences map[string]int
+}
+
+func NewRecordTransport(logger zerolog.Logger, options *ClientOptions, core http.RoundTripper) (*RecordTransport, error) {
+	if err := os.MkdirAll(options.Recording.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create the recording directory: %w", err)
+	}
+	sequences, err := recordedSequences(options.Recording.Dir)
+	if err != nil {
+		return nil, err
+	}
+	logger.Warn().Str("dir", options.Recording.Dir).Msg("Recording the Climkit API exchanges")
+	return &RecordTransport{
+		core:      core,
+		options:   *options,
+		log:       logger,
+		sequences: sequences,
+	}, nil
+}
+
+// recordedSequences returns the next sequence of each request recorded in the directory.
+func recordedSequences(dir string) (map[string]int, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the recordings: %w", err)
+	}
+	sequences := make(map[string]int)
+	for _, file := range files {
+		match := recordingFileName.FindStringSubmatch(file)
+		if match == nil {
+			continue
+		}
+		if sequence, err := strconv.Atoi(match[2]); err == nil && sequence >= sequences[match[1]] {
+			sequences[match[1]] = sequence + 1
+		}
+	}
+	return sequences, nil
+}
+
+func (t *RecordTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	request, r, err := recordRequest(r, t.options.ApiUrl)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.core.RoundTrip(r)
+	if err != nil {
+		return resp, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error reading the response to record: %w", err)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	recording := Recording{
+		Request:  request,
+		Response: recordResponse(resp, body),
+	}
+	if err := t.save(recording); err != nil {
+		t.log.Error().Err(err).Str("path", request.Path).Msg("Unable to save the recording")
+	}
+	return resp, nil
+}
+
+func (t *RecordTransport) save(recording Recording) error {
+	key := recording.Request.key()
+	t.mu.Lock()
+	sequence := t.sequences[key]
+	t.sequences[key]++
+	t.mu.Unlock()
+
+	content, err := json.MarshalIndent(recording, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s-%05d.json", recordingName(recording.Request), key, sequence)
+	return ioutil.WriteFile(filepath.Join(t.options.Recording.Dir, name), content, 0o644)
+}
+
+// ReplayTransport answers the requests with recordings, matched by method, path and body. The data requests are
+// matched with the same time window if one was recorded, otherwise without it: their time window depends on the time
+// of the requests, so they are replayed in their recorded order. Repeated requests get the recordings in their
+// recorded order, the last one being served again once they are exhausted.
+type ReplayTransport struct {
+	log zerolog.Logger
+	// apiUrl is the ApiUrl of the client, the recorded paths are relative to it.
+	apiUrl string
+
+	mu sync.Mutex
+	// recordings are indexed by key, and exact by exactKey.
+	recordings map[string][]Recording
+	exact      map[string][]Recording
+	served     map[string]int
+}
+
+func NewReplayTransport(logger zerolog.Logger, options *ClientOptions) (*ReplayTransport, error) {
+	files, err := filepath.Glob(filepath.Join(options.Recording.Dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the recordings: %w", err)
+	}
+	// the file names end with the sequence of the request.
+	sort.Strings(files)
+
+	recordings := make(map[string][]Recording)
+	exact := make(map[string][]Recording)
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read recording %s: %w", file, err)
+		}
+		var recording Recording
+		if err := json.Unmarshal(content, &recording); err != nil {
+			return nil, fmt.Errorf("invalid recording %s: %w", file, err)
+		}
+		// the key is computed again, the recordings may have been edited.
+		recording.Request.Body = compactJSON(recording.Request.Body)
+		key := recording.Request.key()
+		recordings[key] = append(recordings[key], recording)
+		exactKey := recording.Request.exactKey()
+		exact[exactKey] = append(exact[exactKey], recording)
+	}
+	logger.Warn().Str("dir", options.Recording.Dir).Int("recordings", len(files)).Msg("Replaying recorded Climkit API exchanges")
+
+	return &ReplayTransport{
+		log:        logger,
+		apiUrl:     options.ApiUrl,
+		recordings: recordings,
+		exact:      exact,
+		served:     make(map[string]int),
+	}, nil
+}
+
+func (t *ReplayTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	request, r, err := recordRequest(r, t.apiUrl)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	// the served counts of the exact keys are prefixed, not to mix them with the ones of the keys.
+	key, recordings := "exact:"+request.exactKey(), t.exact[request.exactKey()]
+	if len(recordings) == 0 {
+		key, recordings = request.key(), t.recordings[request.key()]
+	}
+	index := t.served[key]
+	if index < len(recordings)-1 {
+		t.served[key]++
+	}
+	t.mu.Unlock()
+
+	if len(recordings) == 0 {
+		return nil, fmt.Errorf("%s %s %s: %w", request.Method, request.Path, string(request.Body), ErrNoRecording)
+	}
+	return recordings[index].Response.httpResponse(r), nil
+}
+
+func (r RecordedResponse) httpResponse(request *http.Request) *http.Response {
+	body := []byte(r.BodyText)
+	if len(r.Body) > 0 {
+		body = r.Body
+	}
+	header := make(http.Header)
+	for name, values := range r.Header {
+		header[http.CanonicalHeaderKey(name)] = values
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       request,
+	}
+}
+
+// recordRequest returns the sanitized request, and a copy of the original request to send, whose body is still
+// readable.
+func recordRequest(r *http.Request, apiUrl string) (RecordedRequest, *http.Request, error) {
+	request := RecordedRequest{
+		Method: r.Method,
+		Path:   relativePath(r.URL, apiUrl),
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return request, r, nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	_ = r.Body.Close()
+	if err != nil {
+		return request, r, fmt.Errorf("error reading the request to record: %w", err)
+	}
+	r = r.Clone(r.Context())
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	request.Body = compactJSON(redactJSON(body))
+	return request, r, nil
+}
+
+func recordResponse(resp *http.Response, body []byte) RecordedResponse {
+	response := RecordedResponse{
+		StatusCode: resp.StatusCode,
+		Header:     make(map[string][]string),
+	}
+	for _, name := range recordedHeaders {
+		if values := resp.Header.Values(name); len(values) > 0 {
+			response.Header[name] = values
+		}
+	}
+	if json.Valid(body) {
+		response.Body = redactJSON(body)
+	} else {
+		response.BodyText = string(body)
+	}
+	return response
+}
+
+// relativePath returns the path and query of the URL, relative to the API URL.
+func relativePath(u *url.URL, apiUrl string) string {
+	relative := u.Path
+	if base, err := url.Parse(apiUrl); err == nil {
+		relative = strings.TrimPrefix(relative, base.Path)
+	}
+	if u.RawQuery != "" {
+		relative += "?" + u.RawQuery
+	}
+	return relative
+}
+
+// recordingName turns the request into a readable file name prefix, e.g. "POST_v1_site_data_123_electricity".
+func recordingName(request RecordedRequest) string {
+	name := request.Method + "_" + request.Path
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// redactJSON replaces the values of the redactedFields, at any depth. A body that is not JSON is returned as is.
+func redactJSON(body []byte) []byte {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return body
+	}
+	redacted, err := json.Marshal(redactValue(value))
+	if err != nil {
+		return body
+	}
+	return redacted
+}
+
+func redactValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for field, fieldValue := range typed {
+			if redactedFields[field] {
+				typed[field] = redactedOf(fieldValue)
+			} else {
+				typed[field] = redactValue(fieldValue)
+			}
+		}
+	case []interface{}:
+		for i := range typed {
+			typed[i] = redactValue(typed[i])
+		}
+	}
+	return value
+}
+
+// redactedOf returns the replacement of a value, of the same JSON type.
+func redactedOf(value interface{}) interface{} {
+	switch value.(type) {
+	case string:
+		return redactedValue
+	case json.Number:
+		return json.Number("0")
+	default:
+		return nil
+	}
+}
+
+func compactJSON(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, body); err != nil {
+		return body
+	}
+	return compacted.Bytes()
+}
@@ -0,0 +1,129 @@
+package climkit_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gaetancollaud/climkit/pkg/climkit"
+	"github.com/gaetancollaud/climkit/pkg/climkit/climkittest"
+)
+
+func newRecordingClient(t *testing.T, options *climkit.ClientOptions, mode climkit.RecordingMode, dir string) climkit.Client {
+	t.Helper()
+	client, err := climkit.NewClient(options.SetRecording(climkit.RecordingOptions{Mode: mode, Dir: dir}))
+	if err != nil {
+		t.Fatalf("unable to create the client: %v", err)
+	}
+	return client
+}
+
+func TestReplayDataWithAnotherWindow(t *testing.T) {
+	server := climkittest.NewServer()
+	defer server.Close()
+	server.AddInstallation("inst-1", climkit.InstallationInfo{Name: "Home", Timezone: "Europe/Zurich"})
+	meters := []climkit.MeterInfo{{Id: "m-1", Type: string(climkit.Electricity)}}
+	server.AddMeter("inst-1", meters[0])
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	server.GenerateSeries("inst-1", climkit.Electricity, start, start.Add(2*time.Hour))
+
+	dir := t.TempDir()
+	recorder := newRecordingClient(t, server.ClientOptions(), climkit.RecordingRecord, dir)
+	recorded, err := recorder.GetMeterData("inst-1", meters, climkit.Electricity, start, start.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("unable to record: %v", err)
+	}
+	server.Close()
+
+	// the modules request a window relative to the current time, which differs at each run.
+	replayer := newRecordingClient(t, server.ClientOptions(), climkit.RecordingReplay, dir)
+	replayed, err := replayer.GetMeterData("inst-1", meters, climkit.Electricity, start.Add(time.Hour), start.Add(3*time.Hour))
+	if err != nil {
+		t.Fatalf("unable to replay: %v", err)
+	}
+	if len(replayed) != len(recorded) || len(replayed) == 0 {
+		t.Fatalf("got %d replayed samples, want the %d recorded ones", len(replayed), len(recorded))
+	}
+	for i := range recorded {
+		if !replayed[i].Timestamp.Equal(recorded[i].Timestamp) || replayed[i].ProdTotal != recorded[i].ProdTotal {
+			t.Errorf("sample %d: got %+v, want %+v", i, replayed[i], recorded[i])
+		}
+	}
+}
+
+func TestReplayPrefersTheSameWindow(t *testing.T) {
+	server := climkittest.NewServer()
+	defer server.Close()
+	server.AddInstallation("inst-1", climkit.InstallationInfo{Timezone: "Europe/Zurich"})
+	meters := []climkit.MeterInfo{{Id: "m-1", Type: string(climkit.Electricity)}}
+	server.AddMeter("inst-1", meters[0])
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	server.GenerateSeries("inst-1", climkit.Electricity, start, start.Add(2*time.Hour))
+
+	dir := t.TempDir()
+	recorder := newRecordingClient(t, server.ClientOptions(), climkit.RecordingRecord, dir)
+	for _, hour := range []int{0, 1} {
+		if _, err := recorder.GetMeterData("inst-1", meters, climkit.Electricity, start.Add(time.Duration(hour)*time.Hour), start.Add(time.Duration(hour+1)*time.Hour)); err != nil {
+			t.Fatalf("unable to record: %v", err)
+		}
+	}
+	server.Close()
+
+	replayer := newRecordingClient(t, server.ClientOptions(), climkit.RecordingReplay, dir)
+	replayed, err := replayer.GetMeterData("inst-1", meters, climkit.Electricity, start.Add(time.Hour), start.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("unable to replay: %v", err)
+	}
+	if len(replayed) == 0 || !replayed[0].Timestamp.Equal(start.Add(time.Hour)) {
+		t.Fatalf("got %v, want the recording of the second hour", replayed)
+	}
+}
+
+func TestRecordingSessionsAppend(t *testing.T) {
+	server := climkittest.NewServer()
+	defer server.Close()
+	dir := t.TempDir()
+
+	// the sessions are told apart by the time zone, the other fields of the installation are personal.
+	for _, timezone := range []string{"Europe/Zurich", "Europe/Paris"} {
+		info := climkit.InstallationInfo{Name: "Chalet Dupont", SiteRef: "CH-1234-Dupont", Timezone: timezone}
+		info.Address.StreetName = "Rue du Lac"
+		server.AddInstallation("inst-1", info)
+		recorder := newRecordingClient(t, server.ClientOptions(), climkit.RecordingRecord, dir)
+		if _, err := recorder.GetInstallationInfo("inst-1"); err != nil {
+			t.Fatalf("unable to record: %v", err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("got %d recordings (%v), want one per session", len(files), err)
+	}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, personal := range []string{"Rue du Lac", "Chalet Dupont", "CH-1234-Dupont"} {
+			if strings.Contains(string(content), personal) {
+				t.Errorf("%s: %s is not redacted", file, personal)
+			}
+		}
+	}
+
+	replayer := newRecordingClient(t, server.ClientOptions(), climkit.RecordingReplay, dir)
+	for _, want := range []string{"Europe/Zurich", "Europe/Paris", "Europe/Paris"} {
+		info, err := replayer.GetInstallationInfo("inst-1")
+		if err != nil {
+			t.Fatalf("unable to replay: %v", err)
+		}
+		if info.Timezone != want {
+			t.Errorf("got %s, want %s", info.Timezone, want)
+		}
+		if info.Name == "" || info.SiteRef == "" {
+			t.Errorf("got %+v, the redacted fields must keep a value", info)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package climkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// RetryTransport retries the requests failing with a network error, a rate limit or a transient server error, with an
+// exponential backoff and jitter between the attempts. It honors the Retry-After header on 429 and 503. Each attempt is
+// bounded by the RequestTimeout, the waits between the attempts are bounded by the deadline of the caller.
+type RetryTransport struct {
+	core    http.RoundTripper
+	options ClientOptions
+	log     zerolog.Logger
+}
+
+func NewRetryTransport(logger zerolog.Logger, options *ClientOptions, core http.RoundTripper) *RetryTransport {
+	return &RetryTransport{
+		core:    core,
+		options: *options,
+		log:     logger,
+	}
+}
+
+func (t *RetryTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx := r.Context()
+	for attempt := 1; ; attempt++ {
+		attemptRequest, err := t.requestForAttempt(r, attempt)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := t.roundTripAttempt(attemptRequest)
+		if attempt >= t.options.RetryMaxAttempts || !isRetryable(ctx, resp, err) {
+			return resp, err
+		}
+
+		wait := t.backoff(attempt)
+		if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+			if retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); retryAfter > 0 {
+				if retryAfter > t.options.RetryMaxBackoff {
+					// a long wait is left to the caller, which gets the Retry-After with the error.
+					return resp, err
+				}
+				wait = retryAfter
+			}
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			// the next attempt could not complete, the caller gets the last failure instead of a timeout.
+			return resp, err
+		}
+		if resp != nil {
+			// the body must be consumed to reuse the connection
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		logEvent := t.log.Warn().Str("url", r.URL.Path).Int("attempt", attempt).Dur("wait", wait)
+		if err != nil {
+			logEvent = logEvent.Err(err)
+		} else {
+			logEvent = logEvent.Int("status", resp.StatusCode)
+		}
+		logEvent.Msg("Request failed, retrying")
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// roundTripAttempt sends one attempt, bounded by the RequestTimeout. The deadline is released once the body of the
+// response is closed.
+func (t *RetryTransport) roundTripAttempt(r *http.Request) (*http.Response, error) {
+	if t.options.RequestTimeout <= 0 {
+		return t.core.RoundTrip(r)
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), t.options.RequestTimeout)
+	resp, err := t.core.RoundTrip(r.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return resp, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases the context of a request when the body of its response is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// requestForAttempt returns the request to send, with a fresh body for the retries since the previous attempt
+// consumed it.
+func (t *RetryTransport) requestForAttempt(r *http.Request, attempt int) (*http.Request, error) {
+	if attempt == 1 || r.Body == nil || r.Body == http.NoBody {
+		return r, nil
+	}
+	if r.GetBody == nil {
+		return nil, fmt.Errorf("cannot retry request to %s: body cannot be replayed", r.URL.Path)
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return nil, fmt.Errorf("cannot retry request to %s: %w", r.URL.Path, err)
+	}
+	clone := r.Clone(r.Context())
+	clone.Body = body
+	return clone, nil
+}
+
+// backoff returns the wait before the next attempt: exponential from RetryMinBackoff, capped at RetryMaxBackoff, with a
+// random jitter of up to half the delay to avoid synchronized retries.
+func (t *RetryTransport) backoff(attempt int) time.Duration {
+	wait := t.options.RetryMinBackoff
+	for i := 1; i < attempt && wait < t.options.RetryMaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > t.options.RetryMaxBackoff {
+		wait = t.options.RetryMaxBackoff
+	}
+	if wait <= 0 {
+		return 0
+	}
+	half := wait / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func isRetryable(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		// a missing recording stays missing.
+		return !errors.Is(err, ErrNoRecording)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package climkit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// locationRegistry keeps the time zone of each installation, shared by all the copies of a Client.
+type locationRegistry struct {
+	mu        sync.Mutex
+	locations map[string]*time.Location
+}
+
+func newLocationRegistry() *locationRegistry {
+	return &locationRegistry{locations: make(map[string]*time.Location)}
+}
+
+func (r *locationRegistry) get(installationId string) (*time.Location, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	location, found := r.locations[installationId]
+	return location, found
+}
+
+func (r *locationRegistry) set(installationId string, location *time.Location) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.locations[installationId] = location
+}
+
+// loadLocation returns the location of an IANA time zone name, UTC if the name is empty.
+func loadLocation(timezone string) (*time.Location, error) {
+	if timezone == "" {
+		return time.UTC, nil
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone '%s': %w", timezone, err)
+	}
+	return location, nil
+}
+
+// installationLocation returns the time zone of the installation, in which the API reads the request windows and
+// returns the timestamps. The installation info is only requested the first time.
+func (c *Client) installationLocation(ctx context.Context, installationId string) (*time.Location, error) {
+	if location, found := c.locations.get(installationId); found {
+		return location, nil
+	}
+	info, err := c.GetInstallationInfoContext(ctx, installationId)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the time zone of installation %s: %w", installationId, err)
+	}
+	return c.updateLocation(installationId, info), nil
+}
+
+// updateLocation records the time zone of the installation. An unknown time zone falls back to UTC.
+func (c *Client) updateLocation(installationId string, info InstallationInfo) *time.Location {
+	location, err := loadLocation(info.Timezone)
+	if err != nil {
+		c.log.Warn().Err(err).Str("installationId", installationId).Msg("Using UTC for the installation")
+		location = time.UTC
+	}
+	c.locations.set(installationId, location)
+	return location
+}
+
+// formatLocal formats t as a wall clock time of the location, as expected by the API.
+func formatLocal(t time.Time, location *time.Location, layout string) string {
+	return t.In(location).Format(layout)
+}
+
+// timeResolver converts the wall clock timestamps of a series into instants of the installation time zone.
+//
+// When the clocks go back (autumn), an hour of wall clock times occurs twice and a timestamp without offset is
+// ambiguous. The series being ordered, the resolver picks the first instant that is not before the previous timestamp
+// of the series, or before the start of the requested window for the first one. When the clocks go forward (spring),
+// the skipped wall clock times do not exist; they are read with the offset in effect before the change, as
+// time.Date does, and reported.
+type timeResolver struct {
+	location *time.Location
+	// lowerBound is the earliest instant expected for the next timestamp.
+	lowerBound time.Time
+}
+
+func newTimeResolver(location *time.Location, windowStart time.Time) *timeResolver {
+	if location == nil {
+		location = time.UTC
+	}
+	return &timeResolver{location: location, lowerBound: windowStart}
+}
+
+// resolve returns the instant of the wall clock time (whose location is ignored), and an error if the time does not
+// exist in the time zone.
+func (r *timeResolver) resolve(wall time.Time) (time.Time, error) {
+	candidates := wallClockInstants(wall, r.location)
+	var err error
+	var resolved time.Time
+	switch len(candidates) {
+	case 0:
+		resolved = time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), r.location)
+		err = fmt.Errorf("time %s skipped by a daylight saving change in %s", wall.Format(ClimkitMeterTimeFormat), r.location)
+	case 1:
+		resolved = candidates[0]
+	default:
+		resolved = candidates[len(candidates)-1]
+		for _, candidate := range candidates {
+			if !candidate.Before(r.lowerBound) {
+				resolved = candidate
+				break
+			}
+		}
+	}
+	r.observe(resolved)
+	return resolved, err
+}
+
+// observe records a timestamp of the series, the next ones cannot be before it.
+func (r *timeResolver) observe(t time.Time) {
+	if t.After(r.lowerBound) {
+		r.lowerBound = t
+	}
+}
+
+// wallClockInstants returns the instants, in ascending order, at which the clocks of the location show the wall clock
+// time: none in a spring gap, two in the repeated autumn hour, one otherwise.
+func wallClockInstants(wall time.Time, location *time.Location) []time.Time {
+	naive := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), time.UTC)
+	var instants []time.Time
+	// the offsets in effect around the time, a day is more than any daylight saving change.
+	for _, probe := range []time.Time{naive.Add(-24 * time.Hour), naive, naive.Add(24 * time.Hour)} {
+		_, offset := probe.In(location).Zone()
+		instant := naive.Add(-time.Duration(offset) * time.Second).In(location)
+		if !sameWallClock(instant, naive) || containsInstant(instants, instant) {
+			continue
+		}
+		instants = append(instants, instant)
+	}
+	sort.Slice(instants, func(i, j int) bool {
+		return instants[i].Before(instants[j])
+	})
+	return instants
+}
+
+func sameWallClock(t time.Time, naive time.Time) bool {
+	year, month, day := t.Date()
+	hour, minute, second := t.Clock()
+	return year == naive.Year() && month == naive.Month() && day == naive.Day() &&
+		hour == naive.Hour() && minute == naive.Minute() && second == naive.Second() && t.Nanosecond() == naive.Nanosecond()
+}
+
+func containsInstant(instants []time.Time, instant time.Time) bool {
+	for _, other := range instants {
+		if other.Equal(instant) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package climkit
+
+import (
+	"testing"
+	"time"
+	// the tests do not depend on the time zone database of the system.
+	_ "time/tzdata"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("unknown time zone %s: %v", name, err)
+	}
+	return location
+}
+
+func mustParseWall(t *testing.T, value string) time.Time {
+	t.Helper()
+	wall, err := time.Parse(ClimkitMeterTimeFormat, value)
+	if err != nil {
+		t.Fatalf("invalid wall clock time %s: %v", value, err)
+	}
+	return wall
+}
+
+func mustParseInstant(t *testing.T, value string) time.Time {
+	t.Helper()
+	instant, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatalf("invalid instant %s: %v", value, err)
+	}
+	return instant
+}
+
+func TestWallClockInstants(t *testing.T) {
+	zurich := mustLoadLocation(t, "Europe/Zurich")
+	tests := []struct {
+		name string
+		wall string
+		// want are the instants in UTC, in ascending order.
+		want []string
+	}{
+		{"winter", "2024-01-15T12:00:00", []string{"2024-01-15T11:00:00Z"}},
+		{"summer", "2024-07-15T12:00:00", []string{"2024-07-15T10:00:00Z"}},
+		{"before the spring gap", "2024-03-31T01:59:59", []string{"2024-03-31T00:59:59Z"}},
+		{"spring gap start", "2024-03-31T02:00:00", nil},
+		{"spring gap end", "2024-03-31T02:59:59", nil},
+		{"after the spring gap", "2024-03-31T03:00:00", []string{"2024-03-31T01:00:00Z"}},
+		{"before the repeated hour", "2024-10-27T01:59:59", []string{"2024-10-26T23:59:59Z"}},
+		{"repeated hour start", "2024-10-27T02:00:00", []string{"2024-10-27T00:00:00Z", "2024-10-27T01:00:00Z"}},
+		{"repeated hour end", "2024-10-27T02:45:00", []string{"2024-10-27T00:45:00Z", "2024-10-27T01:45:00Z"}},
+		{"after the repeated hour", "2024-10-27T03:00:00", []string{"2024-10-27T02:00:00Z"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instants := wallClockInstants(mustParseWall(t, test.wall), zurich)
+			if len(instants) != len(test.want) {
+				t.Fatalf("got %d instants %v, want %v", len(instants), instants, test.want)
+			}
+			for i, instant := range instants {
+				if want := mustParseInstant(t, test.want[i]); !instant.Equal(want) {
+					t.Errorf("instant %d: got %s, want %s", i, instant.UTC().Format(time.RFC3339), test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTimeResolver(t *testing.T) {
+	zurich := mustLoadLocation(t, "Europe/Zurich")
+	type resolved struct {
+		instant string
+		// offset is the UTC offset of the resolved instant, in hours.
+		offset int
+		// skipped is true if the wall clock time does not exist.
+		skipped bool
+	}
+	tests := []struct {
+		name        string
+		windowStart string
+		walls       []string
+		want        []resolved
+	}{
+		{
+			name:        "autumn repeated hour",
+			windowStart: "2024-10-26T23:30:00Z",
+			walls: []string{"2024-10-27T01:45:00",
+				"2024-10-27T02:00:00", "2024-10-27T02:15:00", "2024-10-27T02:30:00", "2024-10-27T02:45:00",
+				"2024-10-27T02:00:00", "2024-10-27T02:15:00", "2024-10-27T02:30:00", "2024-10-27T02:45:00",
+				"2024-10-27T03:00:00"},
+			want: []resolved{{"2024-10-26T23:45:00Z", 2, false},
+				{"2024-10-27T00:00:00Z", 2, false}, {"2024-10-27T00:15:00Z", 2, false}, {"2024-10-27T00:30:00Z", 2, false}, {"2024-10-27T00:45:00Z", 2, false},
+				{"2024-10-27T01:00:00Z", 1, false}, {"2024-10-27T01:15:00Z", 1, false}, {"2024-10-27T01:30:00Z", 1, false}, {"2024-10-27T01:45:00Z", 1, false},
+				{"2024-10-27T02:00:00Z", 1, false}},
+		},
+		{
+			name:        "autumn window starting in the second occurrence",
+			windowStart: "2024-10-27T01:00:00Z",
+			walls:       []string{"2024-10-27T02:15:00", "2024-10-27T02:30:00", "2024-10-27T03:00:00"},
+			want:        []resolved{{"2024-10-27T01:15:00Z", 1, false}, {"2024-10-27T01:30:00Z", 1, false}, {"2024-10-27T02:00:00Z", 1, false}},
+		},
+		{
+			name:        "autumn series with a missing sample in the first occurrence",
+			windowStart: "2024-10-26T23:30:00Z",
+			walls:       []string{"2024-10-27T02:30:00", "2024-10-27T02:00:00", "2024-10-27T02:45:00"},
+			want:        []resolved{{"2024-10-27T00:30:00Z", 2, false}, {"2024-10-27T01:00:00Z", 1, false}, {"2024-10-27T01:45:00Z", 1, false}},
+		},
+		{
+			name:        "spring missing hour",
+			windowStart: "2024-03-31T00:00:00Z",
+			walls:       []string{"2024-03-31T01:30:00", "2024-03-31T01:45:00", "2024-03-31T03:00:00", "2024-03-31T03:15:00"},
+			want: []resolved{{"2024-03-31T00:30:00Z", 1, false}, {"2024-03-31T00:45:00Z", 1, false},
+				{"2024-03-31T01:00:00Z", 2, false}, {"2024-03-31T01:15:00Z", 2, false}},
+		},
+		{
+			name:        "spring timestamp in the missing hour",
+			windowStart: "2024-03-31T00:00:00Z",
+			walls:       []string{"2024-03-31T01:45:00", "2024-03-31T02:30:00", "2024-03-31T03:00:00"},
+			want:        []resolved{{"2024-03-31T00:45:00Z", 1, false}, {"", 0, true}, {"2024-03-31T01:00:00Z", 2, false}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolver := newTimeResolver(zurich, mustParseInstant(t, test.windowStart))
+			var previous time.Time
+			for i, wall := range test.walls {
+				instant, err := resolver.resolve(mustParseWall(t, wall))
+				want := test.want[i]
+				if want.skipped {
+					if err == nil {
+						t.Errorf("%s: got %s, want a skipped time error", wall, instant.UTC().Format(time.RFC3339))
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("%s: unexpected error: %v", wall, err)
+				}
+				if !instant.Equal(mustParseInstant(t, want.instant)) {
+					t.Errorf("%s: got %s, want %s", wall, instant.UTC().Format(time.RFC3339), want.instant)
+				}
+				if _, offset := instant.Zone(); offset != want.offset*3600 {
+					t.Errorf("%s: got offset %ds, want %dh", wall, offset, want.offset)
+				}
+				if instant.Before(previous) {
+					t.Errorf("%s: %s is before the previous timestamp %s", wall, instant, previous)
+				}
+				previous = instant
+			}
+		})
+	}
+}
+
+func TestFormatLocalRequestWindows(t *testing.T) {
+	zurich := mustLoadLocation(t, "Europe/Zurich")
+	tests := []struct {
+		name     string
+		start    string
+		end      string
+		interval time.Duration
+		layout   string
+		// want are the formatted start and end of each chunk.
+		want [][2]string
+	}{
+		{
+			name:   "autumn window",
+			start:  "2024-10-26T23:00:00Z",
+			end:    "2024-10-27T03:00:00Z",
+			layout: ClimkitTimeFormat,
+			want:   [][2]string{{"2024-10-27 01:00:00", "2024-10-27 04:00:00"}},
+		},
+		{
+			name:     "autumn window split by the hour",
+			start:    "2024-10-26T23:00:00Z",
+			end:      "2024-10-27T02:00:00Z",
+			interval: time.Hour,
+			layout:   ClimkitMeterTimeFormat,
+			want: [][2]string{
+				{"2024-10-27T01:00:00", "2024-10-27T02:00:00"},
+				// the repeated hour, once with each offset.
+				{"2024-10-27T02:00:00", "2024-10-27T02:00:00"},
+				{"2024-10-27T02:00:00", "2024-10-27T03:00:00"},
+			},
+		},
+		{
+			name:   "spring window",
+			start:  "2024-03-31T00:00:00Z",
+			end:    "2024-03-31T02:00:00Z",
+			layout: ClimkitTimeFormat,
+			want:   [][2]string{{"2024-03-31 01:00:00", "2024-03-31 04:00:00"}},
+		},
+		{
+			name:     "spring window split by the hour",
+			start:    "2024-03-30T23:00:00Z",
+			end:      "2024-03-31T02:00:00Z",
+			interval: time.Hour,
+			layout:   ClimkitMeterTimeFormat,
+			want: [][2]string{
+				{"2024-03-31T00:00:00", "2024-03-31T01:00:00"},
+				// the missing hour is never requested.
+				{"2024-03-31T01:00:00", "2024-03-31T03:00:00"},
+				{"2024-03-31T03:00:00", "2024-03-31T04:00:00"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chunks := splitTimeRange(mustParseInstant(t, test.start), mustParseInstant(t, test.end), test.interval)
+			if len(chunks) != len(test.want) {
+				t.Fatalf("got %d chunks, want %d", len(chunks), len(test.want))
+			}
+			for i, chunk := range chunks {
+				start := formatLocal(chunk.start, zurich, test.layout)
+				end := formatLocal(chunk.end, zurich, test.layout)
+				if start != test.want[i][0] || end != test.want[i][1] {
+					t.Errorf("chunk %d: got [%s, %s], want [%s, %s]", i, start, end, test.want[i][0], test.want[i][1])
+				}
+			}
+		})
+	}
+}
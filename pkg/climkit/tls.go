@@ -0,0 +1,67 @@
+package climkit
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSOptions configures the TLS connection to the Climkit API.
+type TLSOptions struct {
+	// CAFile is a PEM bundle of the certificate authorities to trust, in addition to the system ones.
+	CAFile string
+	// CertFile and KeyFile are the PEM encoded client certificate and its key, both optional.
+	CertFile string
+	KeyFile  string
+	// MinVersion is the minimum TLS version accepted: "1.0", "1.1", "1.2" or "1.3".
+	MinVersion string
+	// InsecureSkipVerify disables the verification of the server certificate. Only for testing.
+	InsecureSkipVerify bool
+}
+
+func (o *TLSOptions) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+
+	if o.MinVersion != "" {
+		version, ok := tlsVersions[o.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version '%s'", o.MinVersion)
+		}
+		config.MinVersion = version
+	}
+
+	if o.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in CA file '%s'", o.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
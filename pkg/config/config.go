@@ -2,9 +2,12 @@ package config
 
 import (
 	"fmt"
+	"github.com/gaetancollaud/climkit/pkg/mqtt"
 	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"strings"
+	"time"
 )
 
 type Mode string
@@ -14,17 +17,80 @@ const (
 	Postgres      = "postgres"
 )
 
+// PayloadFormat is the format of the live values published to MQTT.
+type PayloadFormat string
+
+const (
+	// PayloadFields publishes each value as a string on its own topic.
+	PayloadFields PayloadFormat = "fields"
+	// PayloadJSON publishes one JSON message per installation and per meter, with all the values of a sample.
+	PayloadJSON PayloadFormat = "json"
+)
+
 type ConfigClimkit struct {
-	ApiUrl   string
+	ApiUrl           string
+	Username         string
+	Password         string
+	RequestTimeout   time.Duration
+	RetryMaxAttempts int
+	RetryMinBackoff  time.Duration
+	RetryMaxBackoff  time.Duration
+	RateLimit        float64
+	RateBurst        int
+	ChunkInterval    time.Duration
+	ChunkConcurrency int
+	CacheTTL         time.Duration
+	CacheFile        string
+	TLS              ConfigTLS
+	Recording        ConfigRecording
+	// Accounts lists the Climkit logins, each with its own client. When empty, Username and Password are the only
+	// account.
+	Accounts []ConfigAccount
+}
+type ConfigAccount struct {
+	// Label namespaces the MQTT topics and the Postgres rows of the account, required with several accounts.
+	Label    string
 	Username string
 	Password string
 }
+type ConfigRecording struct {
+	// Mode is "record", "replay" or empty to call the API normally.
+	Mode string
+	Dir  string
+}
+type ConfigTLS struct {
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	MinVersion         string
+	InsecureSkipVerify bool
+}
+type ConfigPatterns struct {
+	Include []string
+	Exclude []string
+}
+type ConfigFilter struct {
+	Installations ConfigPatterns
+	SiteRefs      ConfigPatterns
+	Meters        ConfigPatterns
+	MeterTypes    ConfigPatterns
+}
 type ConfigMqtt struct {
 	MqttUrl     string
 	Username    string
 	Password    string
 	TopicPrefix string
 	Retain      bool
+	// PayloadFormat of the live values, the other topics are always published per field.
+	PayloadFormat PayloadFormat
+	// StaleAfter is the age of the last sample of an installation after which its status goes offline.
+	StaleAfter time.Duration
+	// HomeAssistant publishes the Home Assistant MQTT discovery configs of the installations and meters.
+	HomeAssistant ConfigHomeAssistant
+}
+type ConfigHomeAssistant struct {
+	Enabled         bool
+	DiscoveryPrefix string
 }
 type ConfigPostgres struct {
 	Host     string
@@ -40,56 +106,137 @@ type Config struct {
 	Postgres ConfigPostgres
 	Mode     Mode
 	LogLevel string
+	// Meter restricts the history requests to this single meter id. Empty means all the meters.
+	Meter string
+	// Filter selects the installations and meters to mirror, with glob patterns.
+	Filter ConfigFilter
+	// DiscoveryInterval is the schedule of the rediscovery of the installations and meters. Zero disables it.
+	DiscoveryInterval time.Duration
 }
 
 const (
-	undefined              string = "__undefined__"
-	configFile             string = "config.yaml"
-	envKeyMode             string = "mode"
-	envKeyLogLevel         string = "log.level"
-	envKeyClimkitApiUrl    string = "climkit.api-url"
-	envKeyClimkitUsername  string = "climkit.username"
-	envKeyClimkitPassword  string = "climkit.password"
-	envKeyMqttUrl          string = "mqtt.url"
-	envKeyMqttUsername     string = "mqtt.username"
-	envKeyMqttPassword     string = "mqtt.password"
-	envKeyMqttTopicPrefix  string = "mqtt.topic-prefix"
-	envKeyMqttRetain       string = "mqtt.retain"
-	envKeyPostgresHost     string = "postgres.host"
-	envKeyPostgresPort     string = "postgres.port"
-	envKeyPostgresDatabase string = "postgres.database"
-	envKeyPostgresUsername string = "postgres.username"
-	envKeyPostgresPassword string = "postgres.password"
-	envKeyPostgresSslMode  string = "postgres.ssl-mode"
+	undefined                     string = "__undefined__"
+	configFile                    string = "config.yaml"
+	envKeyMode                    string = "mode"
+	envKeyLogLevel                string = "log.level"
+	envKeyMeter                   string = "meter"
+	envKeyDiscoveryInterval       string = "discovery.interval"
+	envKeyFilterInstallationsIn   string = "filter.installations.include"
+	envKeyFilterInstallationsEx   string = "filter.installations.exclude"
+	envKeyFilterSiteRefsIn        string = "filter.site-refs.include"
+	envKeyFilterSiteRefsEx        string = "filter.site-refs.exclude"
+	envKeyFilterMetersIn          string = "filter.meters.include"
+	envKeyFilterMetersEx          string = "filter.meters.exclude"
+	envKeyFilterMeterTypesIn      string = "filter.meter-types.include"
+	envKeyFilterMeterTypesEx      string = "filter.meter-types.exclude"
+	envKeyClimkitApiUrl           string = "climkit.api-url"
+	envKeyClimkitUsername         string = "climkit.username"
+	envKeyClimkitPassword         string = "climkit.password"
+	envKeyClimkitAccounts         string = "climkit.accounts"
+	envKeyClimkitTimeout          string = "climkit.request-timeout"
+	envKeyClimkitRetryMaxAttempts string = "climkit.retry.max-attempts"
+	envKeyClimkitRetryMinBackoff  string = "climkit.retry.min-backoff"
+	envKeyClimkitRetryMaxBackoff  string = "climkit.retry.max-backoff"
+	envKeyClimkitRateLimit        string = "climkit.rate-limit"
+	envKeyClimkitRateBurst        string = "climkit.rate-burst"
+	envKeyClimkitChunkInterval    string = "climkit.chunk.interval"
+	envKeyClimkitChunkConcurrency string = "climkit.chunk.concurrency"
+	envKeyClimkitCacheTTL         string = "climkit.cache.ttl"
+	envKeyClimkitCacheFile        string = "climkit.cache.file"
+	envKeyClimkitRecordingMode    string = "climkit.recording.mode"
+	envKeyClimkitRecordingDir     string = "climkit.recording.dir"
+	envKeyClimkitTLSCAFile        string = "climkit.tls.ca-file"
+	envKeyClimkitTLSCertFile      string = "climkit.tls.cert-file"
+	envKeyClimkitTLSKeyFile       string = "climkit.tls.key-file"
+	envKeyClimkitTLSMinVersion    string = "climkit.tls.min-version"
+	envKeyClimkitTLSInsecure      string = "climkit.tls.insecure-skip-verify"
+	envKeyMqttUrl                 string = "mqtt.url"
+	envKeyMqttUsername            string = "mqtt.username"
+	envKeyMqttPassword            string = "mqtt.password"
+	envKeyMqttTopicPrefix         string = "mqtt.topic-prefix"
+	envKeyMqttRetain              string = "mqtt.retain"
+	envKeyMqttPayloadFormat       string = "mqtt.payload-format"
+	envKeyMqttStaleAfter          string = "mqtt.availability.stale-after"
+	envKeyMqttHAEnabled           string = "mqtt.homeassistant.enabled"
+	envKeyMqttHADiscoveryPrefix   string = "mqtt.homeassistant.discovery-prefix"
+	envKeyPostgresHost            string = "postgres.host"
+	envKeyPostgresPort            string = "postgres.port"
+	envKeyPostgresDatabase        string = "postgres.database"
+	envKeyPostgresUsername        string = "postgres.username"
+	envKeyPostgresPassword        string = "postgres.password"
+	envKeyPostgresSslMode         string = "postgres.ssl-mode"
 )
 
 var defaultConfig = map[string]interface{}{
-	envKeyMode:             undefined,
-	envKeyClimkitApiUrl:    "https://api.climkit.io/api/",
-	envKeyClimkitUsername:  undefined,
-	envKeyClimkitPassword:  undefined,
-	envKeyMqttUrl:          "",
-	envKeyMqttUsername:     "",
-	envKeyMqttPassword:     "",
-	envKeyMqttTopicPrefix:  "climkit",
-	envKeyMqttRetain:       false,
-	envKeyLogLevel:         "INFO",
-	envKeyPostgresHost:     "localhost",
-	envKeyPostgresPort:     "5432",
-	envKeyPostgresDatabase: "postgres",
-	envKeyPostgresUsername: "postgres",
-	envKeyPostgresPassword: "postgres",
-	envKeyPostgresSslMode:  "disable",
-}
-
-// FromEnv returns a Config from env variables
-func ReadConfig() (*Config, error) {
+	envKeyMode:                    undefined,
+	envKeyClimkitApiUrl:           "https://api.climkit.io/api/",
+	envKeyClimkitUsername:         undefined,
+	envKeyClimkitPassword:         undefined,
+	envKeyClimkitTimeout:          "30s",
+	envKeyClimkitRetryMaxAttempts: 5,
+	envKeyClimkitRetryMinBackoff:  "1s",
+	envKeyClimkitRetryMaxBackoff:  "1m",
+	envKeyClimkitRateLimit:        0.5,
+	envKeyClimkitRateBurst:        5,
+	envKeyClimkitChunkInterval:    "720h",
+	envKeyClimkitChunkConcurrency: 1,
+	envKeyClimkitCacheTTL:         "0s",
+	envKeyClimkitCacheFile:        "",
+	envKeyClimkitRecordingMode:    "",
+	envKeyClimkitRecordingDir:     "recordings",
+	envKeyClimkitTLSCAFile:        "",
+	envKeyClimkitTLSCertFile:      "",
+	envKeyClimkitTLSKeyFile:       "",
+	envKeyClimkitTLSMinVersion:    "1.2",
+	envKeyClimkitTLSInsecure:      false,
+	envKeyMqttUrl:                 "",
+	envKeyMqttUsername:            "",
+	envKeyMqttPassword:            "",
+	envKeyMqttTopicPrefix:         "climkit",
+	envKeyMqttRetain:              false,
+	envKeyMqttPayloadFormat:       string(PayloadFields),
+	envKeyMqttStaleAfter:          "1h",
+	envKeyMqttHAEnabled:           false,
+	envKeyMqttHADiscoveryPrefix:   "homeassistant",
+	envKeyLogLevel:                "INFO",
+	envKeyMeter:                   "",
+	envKeyDiscoveryInterval:       "6h",
+	envKeyFilterInstallationsIn:   []string{},
+	envKeyFilterInstallationsEx:   []string{},
+	envKeyFilterSiteRefsIn:        []string{},
+	envKeyFilterSiteRefsEx:        []string{},
+	envKeyFilterMetersIn:          []string{},
+	envKeyFilterMetersEx:          []string{},
+	envKeyFilterMeterTypesIn:      []string{},
+	envKeyFilterMeterTypesEx:      []string{},
+	envKeyPostgresHost:            "localhost",
+	envKeyPostgresPort:            "5432",
+	envKeyPostgresDatabase:        "postgres",
+	envKeyPostgresUsername:        "postgres",
+	envKeyPostgresPassword:        "postgres",
+	envKeyPostgresSslMode:         "disable",
+}
+
+// NewFlagSet returns the command line flags of the configuration, to parse before ReadConfig.
+func NewFlagSet(name string) *pflag.FlagSet {
+	flags := pflag.NewFlagSet(name, pflag.ExitOnError)
+	flags.String(envKeyMeter, "", "only fetch the history of this meter id")
+	return flags
+}
+
+// FromEnv returns a Config from env variables and the parsed flags, created by NewFlagSet.
+func ReadConfig(flags *pflag.FlagSet) (*Config, error) {
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
 	// Set the current directory where the binary is being run.
 	viper.AddConfigPath(".")
 	viper.AutomaticEnv()
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+
+	// Command line flags, they take precedence over the config file and the environment.
+	if err := viper.BindPFlags(flags); err != nil {
+		return nil, fmt.Errorf("unable to bind command line flags: %w", err)
+	}
 	for key, value := range defaultConfig {
 		if value != undefined {
 			viper.SetDefault(key, value)
@@ -106,25 +253,78 @@ func ReadConfig() (*Config, error) {
 		}
 	}
 
+	var accounts []ConfigAccount
+	if err := viper.UnmarshalKey(envKeyClimkitAccounts, &accounts); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envKeyClimkitAccounts, err)
+	}
+
 	// Check for deprecated and undefined fields.
 	for fieldName, defaultValue := range defaultConfig {
+		if len(accounts) > 0 && (fieldName == envKeyClimkitUsername || fieldName == envKeyClimkitPassword) {
+			// the credentials are given per account.
+			continue
+		}
 		if defaultValue == undefined && !viper.IsSet(fieldName) {
 			return nil, fmt.Errorf("required field not found in config: %s", fieldName)
 		}
 	}
 
-	config := &Config{
-		Climkit: ConfigClimkit{
-			ApiUrl:   viper.GetString(envKeyClimkitApiUrl),
+	if len(accounts) == 0 {
+		accounts = []ConfigAccount{{
 			Username: viper.GetString(envKeyClimkitUsername),
 			Password: viper.GetString(envKeyClimkitPassword),
+		}}
+	}
+	if err := validateAccounts(accounts); err != nil {
+		return nil, err
+	}
+
+	payloadFormat := PayloadFormat(viper.GetString(envKeyMqttPayloadFormat))
+	if payloadFormat != PayloadFields && payloadFormat != PayloadJSON {
+		return nil, fmt.Errorf("%s: unknown format '%s', expected '%s' or '%s'", envKeyMqttPayloadFormat, payloadFormat,
+			PayloadFields, PayloadJSON)
+	}
+
+	config := &Config{
+		Climkit: ConfigClimkit{
+			ApiUrl:           viper.GetString(envKeyClimkitApiUrl),
+			Username:         viper.GetString(envKeyClimkitUsername),
+			Password:         viper.GetString(envKeyClimkitPassword),
+			RequestTimeout:   viper.GetDuration(envKeyClimkitTimeout),
+			RetryMaxAttempts: viper.GetInt(envKeyClimkitRetryMaxAttempts),
+			RetryMinBackoff:  viper.GetDuration(envKeyClimkitRetryMinBackoff),
+			RetryMaxBackoff:  viper.GetDuration(envKeyClimkitRetryMaxBackoff),
+			RateLimit:        viper.GetFloat64(envKeyClimkitRateLimit),
+			RateBurst:        viper.GetInt(envKeyClimkitRateBurst),
+			ChunkInterval:    viper.GetDuration(envKeyClimkitChunkInterval),
+			ChunkConcurrency: viper.GetInt(envKeyClimkitChunkConcurrency),
+			CacheTTL:         viper.GetDuration(envKeyClimkitCacheTTL),
+			CacheFile:        viper.GetString(envKeyClimkitCacheFile),
+			Accounts:         accounts,
+			Recording: ConfigRecording{
+				Mode: viper.GetString(envKeyClimkitRecordingMode),
+				Dir:  viper.GetString(envKeyClimkitRecordingDir),
+			},
+			TLS: ConfigTLS{
+				CAFile:             viper.GetString(envKeyClimkitTLSCAFile),
+				CertFile:           viper.GetString(envKeyClimkitTLSCertFile),
+				KeyFile:            viper.GetString(envKeyClimkitTLSKeyFile),
+				MinVersion:         viper.GetString(envKeyClimkitTLSMinVersion),
+				InsecureSkipVerify: viper.GetBool(envKeyClimkitTLSInsecure),
+			},
 		},
 		Mqtt: ConfigMqtt{
-			MqttUrl:     viper.GetString(envKeyMqttUrl),
-			Username:    viper.GetString(envKeyMqttUsername),
-			Password:    viper.GetString(envKeyMqttPassword),
-			TopicPrefix: viper.GetString(envKeyMqttTopicPrefix),
-			Retain:      viper.GetBool(envKeyMqttRetain),
+			MqttUrl:       viper.GetString(envKeyMqttUrl),
+			Username:      viper.GetString(envKeyMqttUsername),
+			Password:      viper.GetString(envKeyMqttPassword),
+			TopicPrefix:   viper.GetString(envKeyMqttTopicPrefix),
+			Retain:        viper.GetBool(envKeyMqttRetain),
+			PayloadFormat: payloadFormat,
+			StaleAfter:    viper.GetDuration(envKeyMqttStaleAfter),
+			HomeAssistant: ConfigHomeAssistant{
+				Enabled:         viper.GetBool(envKeyMqttHAEnabled),
+				DiscoveryPrefix: viper.GetString(envKeyMqttHADiscoveryPrefix),
+			},
 		},
 		Postgres: ConfigPostgres{
 			Host:     viper.GetString(envKeyPostgresHost),
@@ -134,13 +334,66 @@ func ReadConfig() (*Config, error) {
 			Password: viper.GetString(envKeyPostgresPassword),
 			SslMode:  viper.GetString(envKeyPostgresSslMode),
 		},
-		Mode:     Mode(viper.GetString(envKeyMode)),
-		LogLevel: viper.GetString(envKeyLogLevel),
+		Mode:              Mode(viper.GetString(envKeyMode)),
+		LogLevel:          viper.GetString(envKeyLogLevel),
+		Meter:             viper.GetString(envKeyMeter),
+		DiscoveryInterval: viper.GetDuration(envKeyDiscoveryInterval),
+		Filter: ConfigFilter{
+			Installations: readPatterns(envKeyFilterInstallationsIn, envKeyFilterInstallationsEx),
+			SiteRefs:      readPatterns(envKeyFilterSiteRefsIn, envKeyFilterSiteRefsEx),
+			Meters:        readPatterns(envKeyFilterMetersIn, envKeyFilterMetersEx),
+			MeterTypes:    readPatterns(envKeyFilterMeterTypesIn, envKeyFilterMeterTypesEx),
+		},
 	}
 
 	return config, nil
 }
 
+// validateAccounts checks that each account has credentials and, when there are several accounts, a label usable as
+// an MQTT topic level that is unique once normalized.
+func validateAccounts(accounts []ConfigAccount) error {
+	// labels maps the normalized labels to the configured ones.
+	labels := make(map[string]string)
+	for i, account := range accounts {
+		if account.Username == "" || account.Password == "" {
+			return fmt.Errorf("%s[%d]: username and password are required", envKeyClimkitAccounts, i)
+		}
+		if len(accounts) > 1 && account.Label == "" {
+			return fmt.Errorf("%s[%d]: a label is required with several accounts", envKeyClimkitAccounts, i)
+		}
+		if strings.ContainsAny(account.Label, "/+#") {
+			return fmt.Errorf("%s[%d]: label '%s' cannot contain '/', '+' or '#'", envKeyClimkitAccounts, i, account.Label)
+		}
+		// the labels are MQTT topic levels once normalized, "a.b" and "ab" would share their topics.
+		namespace := mqtt.NormalizeForTopicName(account.Label)
+		if len(accounts) > 1 && namespace == "" {
+			return fmt.Errorf("%s[%d]: label '%s' has no character usable in an MQTT topic", envKeyClimkitAccounts, i, account.Label)
+		}
+		if other, found := labels[namespace]; found {
+			return fmt.Errorf("%s[%d]: label '%s' conflicts with label '%s' in the MQTT topics", envKeyClimkitAccounts, i, account.Label, other)
+		}
+		labels[namespace] = account.Label
+	}
+	return nil
+}
+
+func readPatterns(includeKey string, excludeKey string) ConfigPatterns {
+	return ConfigPatterns{
+		Include: viper.GetStringSlice(includeKey),
+		Exclude: viper.GetStringSlice(excludeKey),
+	}
+}
+
+// String describes the Climkit API and accounts, without their passwords.
 func (c *Config) String() string {
-	return fmt.Sprintf("%+v\n", c.Climkit)
+	accounts := make([]string, len(c.Climkit.Accounts))
+	for i, account := range c.Climkit.Accounts {
+		accounts[i] = account.String()
+	}
+	return fmt.Sprintf("{ApiUrl:%s Accounts:[%s]}\n", c.Climkit.ApiUrl, strings.Join(accounts, " "))
+}
+
+// String describes the account without its password, so that it can be logged.
+func (a ConfigAccount) String() string {
+	return fmt.Sprintf("{Label:%s Username:%s}", a.Label, a.Username)
 }
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestValidateAccounts(t *testing.T) {
+	account := func(label string) ConfigAccount {
+		return ConfigAccount{Label: label, Username: "user", Password: "password"}
+	}
+	tests := []struct {
+		name     string
+		accounts []ConfigAccount
+		wantErr  bool
+	}{
+		{"single unlabelled account", []ConfigAccount{account("")}, false},
+		{"several labelled accounts", []ConfigAccount{account("home"), account("office")}, false},
+		{"missing credentials", []ConfigAccount{{Label: "home", Username: "user"}}, true},
+		{"missing label", []ConfigAccount{account("home"), account("")}, true},
+		{"wildcard in label", []ConfigAccount{account("home/+")}, true},
+		{"duplicate label", []ConfigAccount{account("home"), account("home")}, true},
+		{"labels colliding once normalized", []ConfigAccount{account("a.b"), account("ab")}, true},
+		{"label without topic character", []ConfigAccount{account("home"), account("...")}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateAccounts(test.accounts)
+			if (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigStringRedactsPasswords(t *testing.T) {
+	config := &Config{Climkit: ConfigClimkit{
+		ApiUrl:   "https://api.climkit.io/api/",
+		Username: "user",
+		Password: "top-secret",
+		Accounts: []ConfigAccount{
+			{Label: "home", Username: "home-user", Password: "home-secret"},
+			{Label: "office", Username: "office-user", Password: "office-secret"},
+		},
+	}}
+	for _, value := range []string{config.String(), fmt.Sprintf("%v %+v", config.Climkit.Accounts, config.Climkit.Accounts[0])} {
+		if strings.Contains(value, "secret") {
+			t.Errorf("%s: a password is not redacted", value)
+		}
+		if !strings.Contains(value, "home-user") {
+			t.Errorf("%s: the username is missing", value)
+		}
+	}
+}
+
+func TestReadConfigTwice(t *testing.T) {
+	t.Setenv("MODE", "mqtt")
+	t.Setenv("CLIMKIT_USERNAME", "user")
+	t.Setenv("CLIMKIT_PASSWORD", "password")
+	// the flags are not registered globally, reading the configuration again does not redefine them.
+	for _, meter := range []string{"m-1", "m-2"} {
+		flags := NewFlagSet("climkit")
+		if err := flags.Parse([]string{"--meter", meter}); err != nil {
+			t.Fatalf("unable to parse the flags: %v", err)
+		}
+		config, err := ReadConfig(flags)
+		if err != nil {
+			t.Fatalf("unable to read the config: %v", err)
+		}
+		if config.Meter != meter {
+			t.Errorf("got meter %s, want %s", config.Meter, meter)
+		}
+	}
+}
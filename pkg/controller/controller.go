@@ -9,10 +9,12 @@ import (
 	"github.com/gaetancollaud/climkit/pkg/postgres"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"path/filepath"
+	"strings"
 )
 
 type Controller struct {
-	climkit        climkit.Client
+	accounts       []modules.Account
 	mqttClient     mqtt.Client
 	postgresClient postgres.Client
 	log            zerolog.Logger
@@ -20,15 +22,18 @@ type Controller struct {
 	modules map[string]modules.Module
 }
 
-func NewController(cfg *config.Config) *Controller {
+func NewController(cfg *config.Config) (*Controller, error) {
 	logger := log.With().Str("Component", "Controller").Logger()
-	// Create climkit client
-	climkitOption := climkit.NewClientOptions().
-		SetApiUrl(cfg.Climkit.ApiUrl).
-		SetUsername(cfg.Climkit.Username).
-		SetPassword(cfg.Climkit.Password)
 
-	climkit := climkit.NewClient(climkitOption)
+	// Create a climkit client per account
+	var accounts []modules.Account
+	for _, account := range cfg.Climkit.Accounts {
+		climkitClient, err := newClimkitClient(cfg, account)
+		if err != nil {
+			return nil, fmt.Errorf("error creating the climkit client of account '%s': %w", account.Label, err)
+		}
+		accounts = append(accounts, modules.Account{Label: account.Label, Climkit: climkitClient})
+	}
 
 	var mqttClient mqtt.Client
 	if cfg.Mode == config.Mqtt {
@@ -53,19 +58,83 @@ func NewController(cfg *config.Config) *Controller {
 	}
 
 	controller := Controller{
-		climkit:        climkit,
+		accounts:       accounts,
 		mqttClient:     mqttClient,
 		postgresClient: postgresClient,
 		log:            logger,
 		modules:        map[string]modules.Module{},
 	}
 
+	// Each module runs once per account, the topics of an account are published under its label.
 	for name, builder := range modules.Modules {
-		module := builder(mqttClient, postgresClient, climkit, cfg)
-		controller.modules[name] = module
+		for _, account := range accounts {
+			var accountMqttClient mqtt.Client
+			if mqttClient != nil {
+				accountMqttClient = mqtt.NewNamespacedClient(mqttClient, account.Label)
+			}
+			module := builder(accountMqttClient, postgresClient, account, cfg)
+			controller.modules[moduleName(name, account)] = module
+		}
 	}
 
-	return &controller
+	return &controller, nil
+}
+
+func newClimkitClient(cfg *config.Config, account config.ConfigAccount) (climkit.Client, error) {
+	climkitOption := climkit.NewClientOptions().
+		SetApiUrl(cfg.Climkit.ApiUrl).
+		SetUsername(account.Username).
+		SetPassword(account.Password).
+		SetLabel(account.Label).
+		SetRequestTimeout(cfg.Climkit.RequestTimeout).
+		SetRetryMaxAttempts(cfg.Climkit.RetryMaxAttempts).
+		SetRetryBackoff(cfg.Climkit.RetryMinBackoff, cfg.Climkit.RetryMaxBackoff).
+		SetRateLimit(cfg.Climkit.RateLimit, cfg.Climkit.RateBurst).
+		SetChunking(cfg.Climkit.ChunkInterval, cfg.Climkit.ChunkConcurrency).
+		SetCache(cfg.Climkit.CacheTTL, accountCacheFile(cfg.Climkit.CacheFile, account.Label)).
+		SetFilter(climkit.Filter{
+			Installations: climkit.Patterns(cfg.Filter.Installations),
+			SiteRefs:      climkit.Patterns(cfg.Filter.SiteRefs),
+			Meters:        climkit.Patterns(cfg.Filter.Meters),
+			MeterTypes:    climkit.Patterns(cfg.Filter.MeterTypes),
+		}).
+		SetRecording(climkit.RecordingOptions{
+			Mode: climkit.RecordingMode(cfg.Climkit.Recording.Mode),
+			Dir:  accountRecordingDir(cfg.Climkit.Recording.Dir, account.Label),
+		}).
+		SetTLS(climkit.TLSOptions{
+			CAFile:             cfg.Climkit.TLS.CAFile,
+			CertFile:           cfg.Climkit.TLS.CertFile,
+			KeyFile:            cfg.Climkit.TLS.KeyFile,
+			MinVersion:         cfg.Climkit.TLS.MinVersion,
+			InsecureSkipVerify: cfg.Climkit.TLS.InsecureSkipVerify,
+		})
+	return climkit.NewClient(climkitOption)
+}
+
+// accountCacheFile returns the cache file of an account: the configured file suffixed by the label, so that the
+// accounts do not overwrite each other's cache.
+func accountCacheFile(file string, label string) string {
+	if file == "" || label == "" {
+		return file
+	}
+	extension := filepath.Ext(file)
+	return strings.TrimSuffix(file, extension) + "-" + label + extension
+}
+
+// accountRecordingDir returns the recording directory of an account, a sub directory named by the label.
+func accountRecordingDir(dir string, label string) string {
+	if dir == "" || label == "" {
+		return dir
+	}
+	return filepath.Join(dir, label)
+}
+
+func moduleName(name string, account modules.Account) string {
+	if account.Label == "" {
+		return name
+	}
+	return name + "[" + account.Label + "]"
 }
 
 func (c *Controller) Start() error {
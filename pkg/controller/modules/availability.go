@@ -0,0 +1,63 @@
+package modules
+
+import (
+	"time"
+
+	"github.com/gaetancollaud/climkit/pkg/mqtt"
+)
+
+// installationAvailability tracks the freshness of the data of each installation. An installation is online while
+// its last sample is more recent than staleAfter.
+type installationAvailability struct {
+	staleAfter time.Duration
+	// lastData is the timestamp of the last sample received for each installation.
+	lastData map[string]time.Time
+	// published is the last status published for each installation.
+	published map[string]string
+}
+
+func newInstallationAvailability(staleAfter time.Duration) *installationAvailability {
+	return &installationAvailability{
+		staleAfter: staleAfter,
+		lastData:   make(map[string]time.Time),
+		published:  make(map[string]string),
+	}
+}
+
+// observe records a sample of the installation.
+func (a *installationAvailability) observe(installationId string, timestamp time.Time) {
+	if timestamp.After(a.lastData[installationId]) {
+		a.lastData[installationId] = timestamp
+	}
+}
+
+// status returns the current status of the installation.
+func (a *installationAvailability) status(installationId string, now time.Time) string {
+	lastData, found := a.lastData[installationId]
+	if !found || now.Sub(lastData) > a.staleAfter {
+		return mqtt.Offline
+	}
+	return mqtt.Online
+}
+
+// changes returns the installations whose status changed since the last call, or were never published, with their
+// new status. The removed installations go offline.
+func (a *installationAvailability) changes(installations installationMeters, now time.Time) map[string]string {
+	changed := make(map[string]string)
+	for installationId := range installations {
+		if status := a.status(installationId, now); a.published[installationId] != status {
+			changed[installationId] = status
+			a.published[installationId] = status
+		}
+	}
+	for installationId, status := range a.published {
+		if _, found := installations[installationId]; !found {
+			if status != mqtt.Offline {
+				changed[installationId] = mqtt.Offline
+			}
+			delete(a.published, installationId)
+			delete(a.lastData, installationId)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,68 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gaetancollaud/climkit/pkg/climkit"
+	"github.com/rs/zerolog"
+)
+
+// defaultRateLimitWait is used when the API rate limits us without a Retry-After hint.
+const defaultRateLimitWait = 30 * time.Second
+
+// errorAction tells the caller of the climkit client how to proceed after a request.
+type errorAction int
+
+const (
+	// actionNone means the request succeeded.
+	actionNone errorAction = iota
+	// actionSkip means the requested entity is not available, the caller should continue with the next one.
+	actionSkip
+	// actionAbort means no request can succeed for now (bad credentials, stopping), the caller should stop the
+	// current cycle and wait for the next interval.
+	actionAbort
+	// actionFail means the request failed for another reason.
+	actionFail
+)
+
+// callClimkit runs the climkit request, waiting and retrying as long as the API rate limits it. On failure, the error
+// is logged according to its class and the returned action tells the caller how to proceed.
+func callClimkit[T any](ctx context.Context, logger zerolog.Logger, request func() (T, error)) (T, errorAction) {
+	for {
+		result, err := request()
+		if err == nil {
+			return result, actionNone
+		}
+
+		switch {
+		case ctx.Err() != nil:
+			return result, actionAbort
+		case errors.Is(err, climkit.ErrFiltered):
+			logger.Debug().Err(err).Msg("Filtered out, skipping")
+			return result, actionSkip
+		case climkit.IsUnauthorized(err):
+			logger.Error().Err(err).Msg("Climkit rejected the credentials, waiting for the next interval")
+			return result, actionAbort
+		case climkit.IsRateLimited(err):
+			wait := climkit.RetryAfter(err)
+			if wait <= 0 {
+				wait = defaultRateLimitWait
+			}
+			logger.Warn().Err(err).Dur("wait", wait).Msg("Rate limited by Climkit, waiting before retrying")
+			if !sleepContext(ctx, wait) {
+				return result, actionAbort
+			}
+		case climkit.IsNotFound(err):
+			logger.Warn().Err(err).Msg("Not found on Climkit, skipping")
+			return result, actionSkip
+		case climkit.IsForbidden(err):
+			logger.Warn().Err(err).Msg("No access on Climkit, skipping")
+			return result, actionSkip
+		default:
+			logger.Error().Err(err).Msg("Climkit request failed")
+			return result, actionFail
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package modules
+
+import (
+	"context"
+	"time"
+)
+
+// sleepContext pauses the current goroutine for the given duration. It returns false if the context was cancelled in
+// the meantime, in which case the caller should stop its work.
+func sleepContext(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
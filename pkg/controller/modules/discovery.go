@@ -0,0 +1,113 @@
+package modules
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gaetancollaud/climkit/pkg/climkit"
+	"github.com/rs/zerolog"
+)
+
+// installationMeters maps the installation ids to their meters.
+type installationMeters map[string][]climkit.MeterInfo
+
+// keepKnown copies the known meters of an installation that could not be fetched, so that they are not seen as
+// removed.
+func (i installationMeters) keepKnown(known installationMeters, installationId string) {
+	if meters, found := known[installationId]; found {
+		i[installationId] = meters
+	}
+}
+
+// meterRef is a meter and the installation it belongs to.
+type meterRef struct {
+	installationId string
+	meter          climkit.MeterInfo
+}
+
+// installationsDiff lists the changes between two discoveries.
+type installationsDiff struct {
+	addedInstallations   []string
+	removedInstallations []string
+	addedMeters          []meterRef
+	removedMeters        []meterRef
+}
+
+// diffInstallations compares the known installations with the ones currently returned by the API.
+func diffInstallations(known installationMeters, current installationMeters) installationsDiff {
+	var diff installationsDiff
+	for installationId, meters := range current {
+		knownMeters, found := known[installationId]
+		if !found {
+			diff.addedInstallations = append(diff.addedInstallations, installationId)
+		}
+		for _, meter := range meters {
+			if _, found := climkit.FindMeter(knownMeters, meter.Id); !found {
+				diff.addedMeters = append(diff.addedMeters, meterRef{installationId: installationId, meter: meter})
+			}
+		}
+	}
+	for installationId, meters := range known {
+		currentMeters, found := current[installationId]
+		if !found {
+			diff.removedInstallations = append(diff.removedInstallations, installationId)
+		}
+		for _, meter := range meters {
+			if _, found := climkit.FindMeter(currentMeters, meter.Id); !found {
+				diff.removedMeters = append(diff.removedMeters, meterRef{installationId: installationId, meter: meter})
+			}
+		}
+	}
+	sort.Strings(diff.addedInstallations)
+	sort.Strings(diff.removedInstallations)
+	sortMeterRefs(diff.addedMeters)
+	sortMeterRefs(diff.removedMeters)
+	return diff
+}
+
+func sortMeterRefs(refs []meterRef) {
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].installationId != refs[j].installationId {
+			return refs[i].installationId < refs[j].installationId
+		}
+		return refs[i].meter.Id < refs[j].meter.Id
+	})
+}
+
+func (d installationsDiff) empty() bool {
+	return len(d.addedInstallations) == 0 && len(d.removedInstallations) == 0 &&
+		len(d.addedMeters) == 0 && len(d.removedMeters) == 0
+}
+
+// log prints a summary of the changes, or a debug message if nothing changed.
+func (d installationsDiff) log(logger zerolog.Logger) {
+	if d.empty() {
+		logger.Debug().Msg("Discovery: no change")
+		return
+	}
+	logger.Info().
+		Strs("addedInstallations", d.addedInstallations).
+		Strs("removedInstallations", d.removedInstallations).
+		Strs("addedMeters", meterIds(d.addedMeters)).
+		Strs("removedMeters", meterIds(d.removedMeters)).
+		Msgf("Discovery: %d installation(s) added, %d removed, %d meter(s) added, %d removed",
+			len(d.addedInstallations), len(d.removedInstallations), len(d.addedMeters), len(d.removedMeters))
+}
+
+func meterIds(refs []meterRef) []string {
+	ids := make([]string, len(refs))
+	for i, ref := range refs {
+		ids[i] = ref.installationId + "/" + ref.meter.Id
+	}
+	return ids
+}
+
+// discoveryTicks returns the channel of the rediscovery schedule, which never fires if the interval is not positive,
+// and the function stopping it.
+func discoveryTicks(interval time.Duration) (<-chan time.Time, func()) {
+	if interval <= 0 {
+		return nil, func() {}
+	}
+	ticker := time.NewTicker(interval)
+	return ticker.C, ticker.Stop
+}
@@ -0,0 +1,292 @@
+package modules
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pahomqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gaetancollaud/climkit/pkg/mqtt"
+)
+
+// fakeMessage is a message published on the fake MQTT client.
+type fakeMessage struct {
+	topic    string
+	payload  string
+	retained bool
+}
+
+// fakeMqttClient records the published messages instead of sending them to a broker.
+type fakeMqttClient struct {
+	prefix string
+	// retained are the retained messages of the broker, by topic relative to the prefix.
+	retained map[string]string
+
+	mu       sync.Mutex
+	messages []fakeMessage
+}
+
+var _ mqtt.Client = (*fakeMqttClient)(nil)
+
+func newFakeMqttClient(prefix string) *fakeMqttClient {
+	return &fakeMqttClient{prefix: prefix}
+}
+
+func (c *fakeMqttClient) Connect() error {
+	return nil
+}
+
+func (c *fakeMqttClient) Disconnect() error {
+	return nil
+}
+
+func (c *fakeMqttClient) Publish(topic string, message interface{}) error {
+	c.record(c.GetFullTopic(topic), message, false)
+	return nil
+}
+
+func (c *fakeMqttClient) PublishAndLogError(topic string, message interface{}) {
+	_ = c.Publish(topic, message)
+}
+
+func (c *fakeMqttClient) PublishRetained(topic string, message interface{}) error {
+	c.record(c.GetFullTopic(topic), message, true)
+	return nil
+}
+
+func (c *fakeMqttClient) PublishRetainedAndLogError(topic string, message interface{}) {
+	_ = c.PublishRetained(topic, message)
+}
+
+func (c *fakeMqttClient) PublishAbsoluteRetained(topic string, message interface{}) error {
+	c.record(topic, message, true)
+	return nil
+}
+
+func (c *fakeMqttClient) GetFullTopic(topic string) string {
+	return c.prefix + "/" + topic
+}
+
+func (c *fakeMqttClient) ServerStatusTopic() string {
+	return c.prefix + "/server/status"
+}
+
+func (c *fakeMqttClient) ReadRetained(filter string, _ time.Duration) (map[string]string, error) {
+	retained := make(map[string]string)
+	for topic, payload := range c.retained {
+		if matchTopic(filter, topic) {
+			retained[topic] = payload
+		}
+	}
+	return retained, nil
+}
+
+// matchTopic returns true if the topic matches the filter, with the single level wildcard only.
+func matchTopic(filter string, topic string) bool {
+	filterLevels, topicLevels := strings.Split(filter, "/"), strings.Split(topic, "/")
+	if len(filterLevels) != len(topicLevels) {
+		return false
+	}
+	for i := range filterLevels {
+		if filterLevels[i] != "+" && filterLevels[i] != topicLevels[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *fakeMqttClient) RawClient() pahomqtt.Client {
+	return nil
+}
+
+func (c *fakeMqttClient) record(topic string, message interface{}, retained bool) {
+	payload := fmt.Sprint(message)
+	if bytes, ok := message.([]byte); ok {
+		payload = string(bytes)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(c.messages, fakeMessage{topic: topic, payload: payload, retained: retained})
+}
+
+// last returns the last message published on the topic.
+func (c *fakeMqttClient) last(topic string) (fakeMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := len(c.messages) - 1; i >= 0; i-- {
+		if c.messages[i].topic == topic {
+			return c.messages[i], true
+		}
+	}
+	return fakeMessage{}, false
+}
+
+// fakeStatement is a statement run with Execute on the fake Postgres client.
+type fakeStatement struct {
+	query string
+	args  []any
+}
+
+// fakePostgresClient records the executed statements. The queries are answered by rows, nil answers no row.
+type fakePostgresClient struct {
+	db   *sql.DB
+	rows func(query string, args []driver.Value) [][]driver.Value
+	// fail returns the error of the executed statement, nil succeeds.
+	fail func(query string) error
+
+	mu         sync.Mutex
+	statements []fakeStatement
+}
+
+func newFakePostgresClient(rows func(query string, args []driver.Value) [][]driver.Value) *fakePostgresClient {
+	c := &fakePostgresClient{rows: rows}
+	c.db = sql.OpenDB(fakeConnector{c})
+	return c
+}
+
+func (c *fakePostgresClient) Connect() error {
+	return nil
+}
+
+func (c *fakePostgresClient) Disconnect() error {
+	return c.db.Close()
+}
+
+func (c *fakePostgresClient) Migrate() error {
+	return nil
+}
+
+func (c *fakePostgresClient) Execute(query string, args ...any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fail != nil {
+		if err := c.fail(query); err != nil {
+			return err
+		}
+	}
+	c.statements = append(c.statements, fakeStatement{query: query, args: args})
+	return nil
+}
+
+func (c *fakePostgresClient) Select(query string, args ...any) *sql.Row {
+	return c.db.QueryRow(query, args...)
+}
+
+func (c *fakePostgresClient) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.db.Query(query, args...)
+}
+
+// executed returns the statements inserting into or updating the table.
+func (c *fakePostgresClient) executed(table string) []fakeStatement {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pattern := regexp.MustCompile(`^\s*(INSERT INTO|UPDATE) ` + table + `\b`)
+	var statements []fakeStatement
+	for _, statement := range c.statements {
+		if pattern.MatchString(statement.query) {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+// fakeConnector, fakeConn, fakeStmt and fakeRows answer the queries of the fake Postgres client through database/sql,
+// so that Select and Query return genuine rows.
+type fakeConnector struct {
+	client *fakePostgresClient
+}
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return fakeConn(c), nil
+}
+
+func (c fakeConnector) Driver() driver.Driver {
+	return nil
+}
+
+type fakeConn struct {
+	client *fakePostgresClient
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{client: c.client, query: query}, nil
+}
+
+func (c fakeConn) Close() error {
+	return nil
+}
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type fakeStmt struct {
+	client *fakePostgresClient
+	query  string
+}
+
+func (s fakeStmt) Close() error {
+	return nil
+}
+
+func (s fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("use Execute")
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	var rows [][]driver.Value
+	if s.client.rows != nil {
+		rows = s.client.rows(s.query, args)
+	}
+	columns := 0
+	if len(rows) > 0 {
+		columns = len(rows[0])
+	}
+	return &fakeRows{columns: make([]string, columns), rows: rows}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// waitFor polls the condition until it is true, failing the test after a few seconds.
+func waitFor(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", description)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
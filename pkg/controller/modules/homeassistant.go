@@ -0,0 +1,233 @@
+package modules
+
+import (
+	"strings"
+
+	"github.com/gaetancollaud/climkit/pkg/climkit"
+	"github.com/gaetancollaud/climkit/pkg/mqtt"
+)
+
+// Home Assistant device and state classes, see https://www.home-assistant.io/integrations/sensor/.
+const (
+	haDeviceClassEnergy       = "energy"
+	haDeviceClassPower        = "power"
+	haDeviceClassWater        = "water"
+	haStateClassMeasurement   = "measurement"
+	haStateClassTotalIncrease = "total_increasing"
+)
+
+// haSensorConfig is the discovery payload of a Home Assistant MQTT sensor.
+type haSensorConfig struct {
+	Name              string   `json:"name"`
+	UniqueId          string   `json:"unique_id"`
+	ObjectId          string   `json:"object_id"`
+	StateTopic        string   `json:"state_topic"`
+	ValueTemplate     string   `json:"value_template,omitempty"`
+	DeviceClass       string   `json:"device_class,omitempty"`
+	StateClass        string   `json:"state_class,omitempty"`
+	UnitOfMeasurement string   `json:"unit_of_measurement,omitempty"`
+	Device            haDevice `json:"device"`
+	// Availability lists the status topics, the sensor is available when all of them are online.
+	Availability     []haAvailability `json:"availability"`
+	AvailabilityMode string           `json:"availability_mode"`
+}
+
+type haAvailability struct {
+	Topic string `json:"topic"`
+}
+
+// haDevice groups the sensors of an installation.
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+// haSensor is a sensor to announce: its state topic relative to the topic prefix, and its discovery config.
+type haSensor struct {
+	// topic is the state topic, relative to the topic prefix of the MQTT client.
+	topic string
+	// jsonTopic and valueTemplate replace the topic with the JSON payload format, empty if the value is still published
+	// on its own topic.
+	jsonTopic     string
+	valueTemplate string
+	config        haSensorConfig
+}
+
+// withJSON returns the sensor reading the value from a JSON payload.
+func (s haSensor) withJSON(jsonTopic string, valueTemplate string) haSensor {
+	s.jsonTopic = jsonTopic
+	s.valueTemplate = valueTemplate
+	return s
+}
+
+// haInstallationFields are the installation wide values of the electricity, published as average power.
+var haInstallationFields = []struct {
+	field string
+	name  string
+}{
+	{"prod_total", "Production"},
+	{"self", "Self-consumption"},
+	{"to_ext", "Grid export"},
+	{"conso_total", "Consumption"},
+	{"from_ext", "Grid import"},
+	{"storage_charging_total", "Storage charging"},
+	{"storage_discharging_total", "Storage discharging"},
+}
+
+// haInstallationSensors returns the sensors of an installation: the average power of its balance and of its heating
+// and charge points, and for each meter its power and its register index. The index is cumulative, it is the sensor
+// to use in the Energy dashboard. The ids hold the account label, so that the installations of several accounts never
+// share a sensor.
+func haInstallationSensors(account string, installationId string, info climkit.InstallationInfo, meters []climkit.MeterInfo) []haSensor {
+	device := haDevice{
+		Identifiers:  []string{haId(haInstallationKey(account, installationId)...)},
+		Name:         info.Name,
+		Manufacturer: "Climkit",
+		Model:        "Installation",
+	}
+	if device.Name == "" {
+		device.Name = "Climkit " + installationId
+	}
+	installationTopic := "installation/" + installationId
+
+	var sensors []haSensor
+	for _, meterType := range climkit.GetMeterTypes(meters) {
+		switch meterType {
+		case climkit.Electricity:
+			for _, field := range haInstallationFields {
+				sensors = append(sensors, haPowerSensor(device, installationTopic+"/"+field.field+"/power",
+					haId(haInstallationKey(account, installationId, field.field)...), field.name).
+					withJSON(installationTopic, haInstallationTemplate(meterType, field.field)))
+			}
+		case climkit.Heating, climkit.ChargePoint:
+			sensors = append(sensors, haPowerSensor(device, installationTopic+"/"+string(meterType)+"/total/power",
+				haId(haInstallationKey(account, installationId, string(meterType))...), haName(string(meterType))).
+				withJSON(installationTopic, haInstallationTemplate(meterType, "total")))
+		}
+	}
+	for _, meter := range meters {
+		sensors = append(sensors, haMeterSensors(device, account, installationId, meter)...)
+	}
+	return sensors
+}
+
+// haMeterSensors returns the sensors of a meter.
+func haMeterSensors(device haDevice, account string, installationId string, meter climkit.MeterInfo) []haSensor {
+	meterType := climkit.MeterType(meter.Type)
+	if !meterType.IsKnown() {
+		return nil
+	}
+	meterId := func(field string) string {
+		return haId(haInstallationKey(account, installationId, meter.Id, field)...)
+	}
+	meterTopic := "installation/" + installationId + "/meters/" + meter.Id
+	name := haName(meter.Type) + " " + meter.Id
+
+	deviceClass := haDeviceClassEnergy
+	if meterType.IsVolume() {
+		deviceClass = haDeviceClassWater
+	}
+	sensors := []haSensor{{
+		topic: meterTopic + "/raw/value",
+		config: haSensorConfig{
+			Name:              name + " index",
+			UniqueId:          meterId("index"),
+			DeviceClass:       deviceClass,
+			StateClass:        haStateClassTotalIncrease,
+			UnitOfMeasurement: meterType.Unit(),
+			Device:            device,
+		},
+	}}
+
+	switch meterType {
+	case climkit.Electricity:
+		sensors = append(sensors,
+			haPowerSensor(device, meterTopic+"/total/power", meterId("power"), name+" power").
+				withJSON(meterTopic, "{{ value_json.fields.total.power }}"),
+			haPowerSensor(device, meterTopic+"/self/power", meterId("self_power"), name+" self-consumption power").
+				withJSON(meterTopic, "{{ value_json.fields.self.power }}"),
+			haPowerSensor(device, meterTopic+"/ext/power", meterId("ext_power"), name+" grid power").
+				withJSON(meterTopic, "{{ value_json.fields.ext.power }}"))
+	case climkit.Heating:
+		sensors = append(sensors, haPowerSensor(device, meterTopic+"/total/power", meterId("power"), name+" power").
+			withJSON(meterTopic, "{{ value_json.fields.total.power }}"))
+	case climkit.ChargePoint:
+		sensors = append(sensors,
+			haPowerSensor(device, meterTopic+"/total/power", meterId("power"), name+" power").
+				withJSON(meterTopic, "{{ value_json.fields.total.power }}"),
+			haSensor{
+				topic:         meterTopic + "/sessions",
+				jsonTopic:     meterTopic,
+				valueTemplate: "{{ value_json.sessions }}",
+				config: haSensorConfig{
+					Name:       name + " sessions",
+					UniqueId:   meterId("sessions"),
+					StateClass: haStateClassMeasurement,
+					Device:     device,
+				},
+			})
+	}
+	return sensors
+}
+
+func haPowerSensor(device haDevice, topic string, uniqueId string, name string) haSensor {
+	return haSensor{
+		topic: topic,
+		config: haSensorConfig{
+			Name:              name,
+			UniqueId:          uniqueId,
+			DeviceClass:       haDeviceClassPower,
+			StateClass:        haStateClassMeasurement,
+			UnitOfMeasurement: climkit.UnitKW,
+			Device:            device,
+		},
+	}
+}
+
+// haInstallationTemplate extracts the power of a field from the installation payload. The payload only holds the meter
+// types whose data was received, the state is kept when the meter type is missing.
+func haInstallationTemplate(meterType climkit.MeterType, field string) string {
+	return "{{ value_json.meter_types." + string(meterType) + ".fields." + field + ".power if '" + string(meterType) +
+		"' in value_json.meter_types else this.state }}"
+}
+
+// haDiscoveryTopic returns the topic of the discovery config of a sensor.
+func haDiscoveryTopic(discoveryPrefix string, sensor haSensor) string {
+	return discoveryPrefix + "/sensor/" + sensor.config.Device.Identifiers[0] + "/" + sensor.config.UniqueId + "/config"
+}
+
+// haInstallationKey returns the parts identifying an installation, followed by the given parts. The account label is
+// normalized as in the MQTT topics, where the labels are checked to be unique, and omitted if empty, which keeps the
+// ids of a single unlabelled account.
+func haInstallationKey(account string, installationId string, parts ...string) []string {
+	key := []string{mqtt.NormalizeForTopicName(account), installationId}
+	return append(key, parts...)
+}
+
+// haId joins the non-empty parts into an identifier accepted by Home Assistant in the topics and ids.
+func haId(parts ...string) string {
+	id := "climkit"
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		id += "_" + strings.Map(func(r rune) rune {
+			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' {
+				return r
+			}
+			return '_'
+		}, part)
+	}
+	return id
+}
+
+// haName turns a meter type into a readable name, e.g. "cold_water" into "Cold water".
+func haName(meterType string) string {
+	name := strings.ReplaceAll(meterType, "_", " ")
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
@@ -0,0 +1,110 @@
+package modules
+
+import (
+	"time"
+
+	"github.com/gaetancollaud/climkit/pkg/climkit"
+)
+
+// liveSample is the JSON payload of the last sample of a meter type or of a meter. The sample covers the interval
+// from Start to End, End being the timestamp returned by the API.
+type liveSample struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Interval is the length of the sample, in seconds.
+	Interval int                     `json:"interval"`
+	Fields   map[string]liveQuantity `json:"fields"`
+	// Units of the quantities and of the interval.
+	Units map[string]string `json:"units"`
+}
+
+// liveQuantity is a value as energy (kWh) and average power (kW), or as volume (m³).
+type liveQuantity struct {
+	Energy *climkit.Energy `json:"energy,omitempty"`
+	Power  *climkit.Power  `json:"power,omitempty"`
+	Volume *climkit.Volume `json:"volume,omitempty"`
+}
+
+// installationPayload is the JSON payload of an installation, with the last sample of each of its meter types.
+type installationPayload struct {
+	InstallationId string                `json:"installation_id"`
+	MeterTypes     map[string]liveSample `json:"meter_types"`
+}
+
+// meterPayload is the JSON payload of a meter.
+type meterPayload struct {
+	InstallationId string `json:"installation_id"`
+	MeterId        string `json:"meter_id"`
+	Type           string `json:"type"`
+	liveSample
+	// Sessions is only set for the charge points.
+	Sessions *int `json:"sessions,omitempty"`
+}
+
+func newInstallationPayload(installationId string) installationPayload {
+	return installationPayload{
+		InstallationId: installationId,
+		MeterTypes:     make(map[string]liveSample),
+	}
+}
+
+// add sets the last sample of a meter type: the energy balance for the electricity, the total otherwise.
+func (p installationPayload) add(data climkit.MeterData) {
+	values := map[string]float64{"total": data.ConsoTotal}
+	if data.Type == climkit.Electricity {
+		values = map[string]float64{
+			"prod_total":                data.ProdTotal,
+			"self":                      data.Self,
+			"to_ext":                    data.ToExt,
+			"conso_total":               data.ConsoTotal,
+			"from_ext":                  data.FromExt,
+			"storage_charging_total":    data.StorageChargingTotal,
+			"storage_discharging_total": data.StorageDischargingTotal,
+		}
+	}
+	p.MeterTypes[string(data.Type)] = newLiveSample(data.Type, values, data.Timestamp, data.Interval)
+}
+
+func newMeterPayload(installationId string, meterType climkit.MeterType, meterValue climkit.MeterDataItem, valueTime time.Time, interval time.Duration) meterPayload {
+	values := map[string]float64{"total": meterValue.Total}
+	if meterType == climkit.Electricity {
+		values["self"] = meterValue.Self
+		values["ext"] = meterValue.Ext
+	}
+	payload := meterPayload{
+		InstallationId: installationId,
+		MeterId:        meterValue.MeterId,
+		Type:           string(meterType),
+		liveSample:     newLiveSample(meterType, values, valueTime, interval),
+	}
+	if meterType == climkit.ChargePoint {
+		sessions := meterValue.Sessions
+		payload.Sessions = &sessions
+	}
+	return payload
+}
+
+func newLiveSample(meterType climkit.MeterType, values map[string]float64, valueTime time.Time, interval time.Duration) liveSample {
+	sample := liveSample{
+		Start:    valueTime.Add(-interval),
+		End:      valueTime,
+		Interval: int(interval.Seconds()),
+		Fields:   make(map[string]liveQuantity),
+		Units:    map[string]string{"interval": "s"},
+	}
+	for field, value := range values {
+		quantity := climkit.NewQuantity(meterType, value, interval)
+		if quantity.IsVolume {
+			sample.Fields[field] = liveQuantity{Volume: &quantity.Volume}
+		} else {
+			sample.Fields[field] = liveQuantity{Energy: &quantity.Energy, Power: &quantity.Power}
+		}
+	}
+	if meterType.IsVolume() {
+		sample.Units["volume"] = climkit.UnitM3
+	} else {
+		sample.Units["energy"] = climkit.UnitKWh
+		sample.Units["power"] = climkit.UnitKW
+	}
+	return sample
+}
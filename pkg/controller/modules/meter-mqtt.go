@@ -1,6 +1,7 @@
 package modules
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gaetancollaud/climkit/pkg/climkit"
@@ -9,24 +10,48 @@ import (
 	"github.com/gaetancollaud/climkit/pkg/postgres"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"strings"
 	"time"
 )
 
+// retainedReadWait is the time given to the broker to send the retained messages after the subscription.
+const retainedReadWait = 2 * time.Second
+
 type MeterMqttModule struct {
-	log              zerolog.Logger
-	mqttClient       mqtt.Client
-	climkit          climkit.Client
-	timerQuitChannel chan struct{}
-	installations    map[string]([]climkit.MeterInfo)
+	log           zerolog.Logger
+	mqttClient    mqtt.Client
+	climkit       climkit.Client
+	ctx           context.Context
+	cancel        context.CancelFunc
+	done          chan struct{}
+	installations installationMeters
+	meter         string
+	// discoveryInterval is the schedule of the rediscovery of the installations and meters, zero disables it.
+	discoveryInterval time.Duration
+	homeAssistant     config.ConfigHomeAssistant
+	payloadFormat     config.PayloadFormat
+	availability      *installationAvailability
+	// account is the label of the Climkit account, empty if there is a single one.
+	account string
 }
 
-func NewMeterMqttModule(mqttClient mqtt.Client, _ postgres.Client, climkitClient climkit.Client, config *config.Config) Module {
-	logger := log.With().Str("Component", "MeterMqttModule").Logger()
+func NewMeterMqttModule(mqttClient mqtt.Client, _ postgres.Client, account Account, config *config.Config) Module {
+	logContext := log.With().Str("Component", "MeterMqttModule")
+	if account.Label != "" {
+		logContext = logContext.Str("account", account.Label)
+	}
+	logger := logContext.Logger()
 	return &MeterMqttModule{
-		mqttClient:    mqttClient,
-		climkit:       climkitClient,
-		log:           logger,
-		installations: make(map[string]([]climkit.MeterInfo)),
+		mqttClient:        mqttClient,
+		climkit:           account.Climkit,
+		account:           account.Label,
+		log:               logger,
+		installations:     make(installationMeters),
+		meter:             config.Meter,
+		discoveryInterval: config.DiscoveryInterval,
+		homeAssistant:     config.Mqtt.HomeAssistant,
+		payloadFormat:     config.Mqtt.PayloadFormat,
+		availability:      newInstallationAvailability(config.Mqtt.StaleAfter),
 	}
 }
 
@@ -35,20 +60,29 @@ func (mm *MeterMqttModule) Eligible() bool {
 }
 
 func (mm *MeterMqttModule) Start() error {
-	mm.fetchAndPublishInstallationInformation()
-	mm.fetchAndPublishMeterValue()
-
-	ticker := time.NewTicker(15 * time.Minute)
-	mm.timerQuitChannel = make(chan struct{})
+	mm.ctx, mm.cancel = context.WithCancel(context.Background())
+	mm.done = make(chan struct{})
 
 	go func() {
+		defer close(mm.done)
+		mm.discover(mm.ctx)
+		mm.fetchAndPublishMeterValue()
+		mm.fetchAndPublishRawReadings()
+
+		ticker := time.NewTicker(15 * time.Minute)
+		defer ticker.Stop()
+		discoveryTicker, stopDiscovery := discoveryTicks(mm.discoveryInterval)
+		defer stopDiscovery()
 		for {
 			select {
+			case <-discoveryTicker:
+				// the cached metadata would hide the added and removed meters.
+				mm.discover(climkit.WithFreshMetadata(mm.ctx))
 			case <-ticker.C:
 				mm.fetchAndPublishMeterValue()
-			case <-mm.timerQuitChannel:
+				mm.fetchAndPublishRawReadings()
+			case <-mm.ctx.Done():
 				mm.log.Info().Msg("Stopping interval requests")
-				ticker.Stop()
 				return
 			}
 		}
@@ -56,8 +90,10 @@ func (mm *MeterMqttModule) Start() error {
 	return nil
 }
 
+// Stop cancels the in-flight requests and waits for the interval loop to return.
 func (mm *MeterMqttModule) Stop() error {
-	close(mm.timerQuitChannel)
+	mm.cancel()
+	<-mm.done
 	return nil
 }
 
@@ -65,24 +101,70 @@ func init() {
 	Register("meter-mqtt", NewMeterMqttModule)
 }
 
-func (mm *MeterMqttModule) fetchAndPublishInstallationInformation() {
-	installationIds, err := mm.climkit.GetInstallationIds()
-	if err != nil {
-		mm.log.Error().Err(err).Msg("Unable to get installations list")
+// discover publishes the installations and meters returned by the API and flags the meters that disappeared as
+// decommissioned.
+func (mm *MeterMqttModule) discover(ctx context.Context) {
+	if len(mm.installations) == 0 {
+		// after a restart, compare with the retained meters, so that the meters removed meanwhile are flagged too.
+		mm.installations = mm.getRetainedMeters()
+	}
+	discovered, ok := mm.fetchAndPublishInstallationInformation(ctx)
+	if !ok {
+		return
+	}
+	diff := diffInstallations(mm.installations, discovered)
+	diff.log(mm.log)
+	for _, removed := range diff.removedMeters {
+		mm.publishMeterDecommissioned(removed.installationId, removed.meter.Id, true)
+		mm.removeHomeAssistantMeter(removed.installationId, removed.meter)
+	}
+	mm.installations = discovered
+}
+
+// fetchAndPublishInstallationInformation returns the installations and meters currently returned by the API,
+// and false if the discovery was interrupted. An installation that cannot be fetched keeps its known meters, unless it
+// is not found anymore.
+func (mm *MeterMqttModule) fetchAndPublishInstallationInformation(ctx context.Context) (installationMeters, bool) {
+	installationIds, action := callClimkit(ctx, mm.log, func() ([]string, error) {
+		return mm.climkit.GetInstallationIdsContext(ctx)
+	})
+	if action != actionNone {
+		return nil, false
 	}
 	mm.log.Info().Strs("installationIds", installationIds).Msg("installation retrieved")
 
+	discovered := make(installationMeters)
+
 	for i := range installationIds {
 		installationId := installationIds[i]
-		info, err := mm.climkit.GetInstallationInfo(installationId)
-		if err != nil {
-			mm.log.Error().Err(err).Msg("Unable to get installation information")
+		logger := mm.log.With().Str("installationId", installationId).Logger()
+
+		info, action := callClimkit(ctx, logger, func() (climkit.InstallationInfo, error) {
+			return mm.climkit.GetInstallationInfoContext(ctx, installationId)
+		})
+		if action == actionAbort {
+			return nil, false
+		} else if action == actionFail {
+			discovered.keepKnown(mm.installations, installationId)
+			continue
+		} else if action != actionNone {
+			continue
 		}
 		infoStr, _ := json.Marshal(info)
 		mm.log.Info().RawJSON("info", infoStr).Msg("got installation info")
 		mm.publishInstallation(installationId, info)
 
-		meters, err := mm.climkit.GetMetersInfos(installationIds[i])
+		meters, action := callClimkit(ctx, logger, func() ([]climkit.MeterInfo, error) {
+			return mm.climkit.GetMetersInfosContext(ctx, installationId)
+		})
+		if action == actionAbort {
+			return nil, false
+		} else if action == actionFail {
+			discovered.keepKnown(mm.installations, installationId)
+			continue
+		} else if action != actionNone {
+			continue
+		}
 		metersStr, _ := json.Marshal(meters)
 		mm.log.Info().RawJSON("meters", metersStr).Msg("got installation meters")
 
@@ -90,22 +172,114 @@ func (mm *MeterMqttModule) fetchAndPublishInstallationInformation() {
 			meterInfo := meters[j]
 			mm.publishMeterInfo(installationId, meterInfo)
 		}
+		mm.publishHomeAssistantDiscovery(installationId, info, meters)
+
+		sensors, action := callClimkit(ctx, logger, func() ([]climkit.Sensor, error) {
+			return mm.climkit.GetSensorsContext(ctx, installationId)
+		})
+		if action == actionAbort {
+			return nil, false
+		}
+		sensorsStr, _ := json.Marshal(sensors)
+		mm.log.Info().RawJSON("sensors", sensorsStr).Msg("got installation sensors")
 
-		mm.installations[installationId] = meters
+		for _, sensor := range sensors {
+			mm.publishSensorInfo(installationId, sensor)
+		}
+
+		discovered[installationId] = meters
 	}
+	return discovered, true
 }
 
 func (mm *MeterMqttModule) fetchAndPublishMeterValue() {
+	defer mm.publishAvailability()
+	if mm.meter != "" {
+		mm.fetchAndPublishSingleMeterValue()
+		return
+	}
 	for installationId, meters := range mm.installations {
-		timeSeries, err := mm.climkit.GetMeterData(installationId, meters, climkit.Electricity, time.Now().Add(-time.Minute*30), time.Now().Add(time.Hour*24))
-		if err != nil {
-			mm.log.Error().Err(err).Msg("Unable to get metric data")
+		payload := newInstallationPayload(installationId)
+		for _, meterType := range climkit.GetMeterTypes(meters) {
+			logger := mm.log.With().Str("installationId", installationId).Str("meterType", string(meterType)).Logger()
+			timeSeries, action := callClimkit(mm.ctx, logger, func() ([]climkit.MeterData, error) {
+				return mm.climkit.GetMeterDataContext(mm.ctx, installationId, meters, meterType, time.Now().Add(-time.Minute*30), time.Now().Add(time.Hour*24))
+			})
+			if action == actionAbort {
+				return
+			}
+			timeSeriesStr, _ := json.Marshal(timeSeries)
+			mm.log.Info().Str("meterType", string(meterType)).RawJSON("timeSeries", timeSeriesStr).Msg("got data")
+
+			if len(timeSeries) == 0 {
+				continue
+			}
+			last := timeSeries[len(timeSeries)-1]
+			mm.availability.observe(installationId, last.Timestamp)
+			switch {
+			case mm.payloadFormat == config.PayloadJSON:
+				payload.add(last)
+				for i := range last.Meters {
+					mm.publishMeterLiveValue(installationId, last.Type, last.Meters[i], last.Timestamp, last.Interval)
+				}
+			case meterType == climkit.Electricity:
+				mm.publishMetersLiveValue(installationId, last)
+			default:
+				mm.publishConsumptionLiveValue(installationId, last)
+			}
+		}
+		if mm.payloadFormat == config.PayloadJSON && len(payload.MeterTypes) > 0 {
+			mm.publishJSON("installation/"+installationId, payload)
+		}
+	}
+}
+
+// fetchAndPublishSingleMeterValue only publishes the value of the configured meter.
+func (mm *MeterMqttModule) fetchAndPublishSingleMeterValue() {
+	for installationId, meters := range mm.installations {
+		meter, found := climkit.FindMeter(meters, mm.meter)
+		if !found {
+			continue
+		}
+		logger := mm.log.With().Str("installationId", installationId).Str("meterId", meter.Id).Logger()
+		timeSeries, action := callClimkit(mm.ctx, logger, func() ([]climkit.SingleMeterData, error) {
+			return mm.climkit.GetSingleMeterDataContext(mm.ctx, installationId, meter.Id, time.Now().Add(-time.Minute*30), time.Now().Add(time.Hour*24))
+		})
+		if action == actionAbort {
+			return
 		}
 		timeSeriesStr, _ := json.Marshal(timeSeries)
-		mm.log.Info().RawJSON("timeSeries", timeSeriesStr).Msg("got data")
+		mm.log.Info().Str("meterId", meter.Id).RawJSON("timeSeries", timeSeriesStr).Msg("got data")
 
+		if len(timeSeries) == 0 {
+			continue
+		}
 		last := timeSeries[len(timeSeries)-1]
-		mm.publishMetersLiveValue(installationId, last)
+		mm.availability.observe(installationId, last.Timestamp)
+		mm.publishMeterLiveValue(installationId, climkit.MeterType(meter.Type), last.MeterDataItem, last.Timestamp, last.Interval)
+	}
+}
+
+// fetchAndPublishRawReadings publishes the latest register value of each meter (or only the configured meter) as
+// retained messages, so that a new subscriber immediately gets the current index.
+func (mm *MeterMqttModule) fetchAndPublishRawReadings() {
+	for installationId, meters := range mm.installations {
+		for _, meter := range meters {
+			if mm.meter != "" && meter.Id != mm.meter {
+				continue
+			}
+			logger := mm.log.With().Str("installationId", installationId).Str("meterId", meter.Id).Logger()
+			readings, action := callClimkit(mm.ctx, logger, func() ([]climkit.RawReading, error) {
+				return mm.climkit.GetMeterRawDataContext(mm.ctx, installationId, meter.Id, time.Now().Add(-time.Hour))
+			})
+			if action == actionAbort {
+				return
+			}
+			if len(readings) == 0 {
+				continue
+			}
+			mm.publishMeterRawReading(installationId, climkit.MeterType(meter.Type), readings[len(readings)-1])
+		}
 	}
 }
 
@@ -121,23 +295,221 @@ func (mm *MeterMqttModule) publishInstallation(installationId string, installati
 func (mm *MeterMqttModule) publishMeterInfo(installationId string, meter climkit.MeterInfo) {
 	mm.mqttClient.PublishAndLogError("installation/"+installationId+"/meters/"+meter.Id+"/type", meter.Type)
 	mm.mqttClient.PublishAndLogError("installation/"+installationId+"/meters/"+meter.Id+"/prim_ad", fmt.Sprintf("%d", meter.PrimAd))
-	mm.mqttClient.PublishAndLogError("installation/"+installationId+"/meters/"+meter.Id+"/virtual", fmt.Sprintf("%d", meter.PrimAd))
+	mm.mqttClient.PublishAndLogError("installation/"+installationId+"/meters/"+meter.Id+"/virtual", fmt.Sprintf("%t", meter.Virtual))
+	mm.publishMeterDecommissioned(installationId, meter.Id, false)
+}
+
+// publishMeterDecommissioned flags a meter no longer returned by the API. Its topics are kept, the flag is retained so
+// that the subscribers know its values are not updated anymore.
+func (mm *MeterMqttModule) publishMeterDecommissioned(installationId string, meterId string, decommissioned bool) {
+	mm.mqttClient.PublishRetainedAndLogError("installation/"+installationId+"/meters/"+meterId+"/decommissioned", fmt.Sprintf("%t", decommissioned))
+}
+
+// getRetainedMeters returns the meters not flagged as decommissioned in the retained messages of the broker, by
+// installation. Their type is only known if the meter info is retained too (retain option).
+func (mm *MeterMqttModule) getRetainedMeters() installationMeters {
+	active := make(installationMeters)
+	retained, err := mm.mqttClient.ReadRetained("installation/+/meters/+/+", retainedReadWait)
+	if err != nil {
+		mm.log.Error().Err(err).Msg("Unable to get the retained meters")
+		return active
+	}
+	types := make(map[string]string)
+	for topic, payload := range retained {
+		if levels := strings.Split(topic, "/"); len(levels) == 5 && levels[4] == "type" {
+			types[levels[1]+"/"+levels[3]] = payload
+		}
+	}
+	for topic, payload := range retained {
+		levels := strings.Split(topic, "/")
+		if len(levels) != 5 || levels[4] != "decommissioned" || payload != "false" {
+			continue
+		}
+		installationId, meterId := levels[1], levels[3]
+		meter := climkit.MeterInfo{Id: meterId, Type: types[installationId+"/"+meterId]}
+		active[installationId] = append(active[installationId], meter)
+	}
+	return active
 }
 
+// publishAvailability publishes the status of the installations whose data went stale or fresh again, retained.
+func (mm *MeterMqttModule) publishAvailability() {
+	for installationId, status := range mm.availability.changes(mm.installations, time.Now()) {
+		mm.log.Info().Str("installationId", installationId).Str("status", status).Msg("Installation availability changed")
+		mm.mqttClient.PublishRetainedAndLogError(installationStatusTopic(installationId), status)
+	}
+}
+
+// installationStatusTopic is online while the data of the installation is fresh, offline otherwise.
+func installationStatusTopic(installationId string) string {
+	return "installation/" + installationId + "/status"
+}
+
+// publishHomeAssistantDiscovery announces the sensors of the installation to Home Assistant, as retained configs so
+// that Home Assistant finds them after a restart. In JSON mode, the sensors read their value from the installation and
+// meter payloads. The sensors are unavailable while the bridge is offline or the data
+// of the installation is stale.
+func (mm *MeterMqttModule) publishHomeAssistantDiscovery(installationId string, info climkit.InstallationInfo, meters []climkit.MeterInfo) {
+	if !mm.homeAssistant.Enabled {
+		return
+	}
+	jsonPayloads := mm.payloadFormat == config.PayloadJSON
+	for _, sensor := range haInstallationSensors(mm.account, installationId, info, meters) {
+		config := sensor.config
+		config.ObjectId = config.UniqueId
+		config.StateTopic = mm.mqttClient.GetFullTopic(sensor.topic)
+		if jsonPayloads && sensor.jsonTopic != "" {
+			config.StateTopic = mm.mqttClient.GetFullTopic(sensor.jsonTopic)
+			config.ValueTemplate = sensor.valueTemplate
+		}
+		config.Availability = []haAvailability{
+			{Topic: mm.mqttClient.ServerStatusTopic()},
+			{Topic: mm.mqttClient.GetFullTopic(installationStatusTopic(installationId))},
+		}
+		config.AvailabilityMode = "all"
+		payload, err := json.Marshal(config)
+		if err != nil {
+			mm.log.Error().Err(err).Str("sensor", config.UniqueId).Msg("Unable to serialize the Home Assistant config")
+			continue
+		}
+		mm.publishHomeAssistantConfig(haDiscoveryTopic(mm.homeAssistant.DiscoveryPrefix, sensor), payload)
+	}
+}
+
+// removeHomeAssistantMeter removes the sensors of a decommissioned meter from Home Assistant, with empty configs. The
+// sensors of every type are removed if the type of the meter is unknown, e.g. retained before a restart.
+func (mm *MeterMqttModule) removeHomeAssistantMeter(installationId string, meter climkit.MeterInfo) {
+	if !mm.homeAssistant.Enabled {
+		return
+	}
+	meters := []climkit.MeterInfo{meter}
+	if meter.Type == "" {
+		meters = nil
+		for _, meterType := range climkit.MeterTypes {
+			meters = append(meters, climkit.MeterInfo{Id: meter.Id, Type: string(meterType)})
+		}
+	}
+	device := haDevice{Identifiers: []string{haId(haInstallationKey(mm.account, installationId)...)}}
+	for _, meter := range meters {
+		for _, sensor := range haMeterSensors(device, mm.account, installationId, meter) {
+			mm.publishHomeAssistantConfig(haDiscoveryTopic(mm.homeAssistant.DiscoveryPrefix, sensor), "")
+		}
+	}
+}
+
+func (mm *MeterMqttModule) publishHomeAssistantConfig(topic string, payload interface{}) {
+	if err := mm.mqttClient.PublishAbsoluteRetained(topic, payload); err != nil {
+		mm.log.Error().Str("topic", topic).Err(err).Msg("Cannot publish")
+	}
+}
+
+func (mm *MeterMqttModule) publishSensorInfo(installationId string, sensor climkit.Sensor) {
+	mm.mqttClient.PublishAndLogError("installation/"+installationId+"/sensors/"+sensor.Id+"/type", sensor.Type)
+	mm.mqttClient.PublishAndLogError("installation/"+installationId+"/sensors/"+sensor.Id+"/name", sensor.Name)
+	mm.mqttClient.PublishAndLogError("installation/"+installationId+"/sensors/"+sensor.Id+"/unit", sensor.Unit)
+}
+
+// publishMetersLiveValue publishes the energy balance of the last sample of the electricity meters: production,
+// self-consumption, export, consumption, import and storage. For each value, the topic itself holds the average power
+// in kW, for compatibility, and its "energy" (kWh) and "power" (kW) subtopics the typed values.
 func (mm *MeterMqttModule) publishMetersLiveValue(installationId string, lastValues climkit.MeterData) {
 	timestamp := lastValues.Timestamp.Format(time.RFC3339)
+	installationTopic := "installation/" + installationId
 
-	mm.mqttClient.PublishAndLogError("installation/"+installationId+"/prod_total", fmt.Sprintf("%f", lastValues.ProdTotal*4))
-	mm.mqttClient.PublishAndLogError("installation/"+installationId+"/self", fmt.Sprintf("%f", lastValues.Self*4))
-	mm.mqttClient.PublishAndLogError("installation/"+installationId+"/to_ext", fmt.Sprintf("%f", lastValues.ToExt*4))
-	mm.mqttClient.PublishAndLogError("installation/"+installationId+"/timestamp", timestamp)
+	mm.publishPower(installationTopic+"/prod_total", lastValues.Quantity(lastValues.ProdTotal))
+	mm.publishPower(installationTopic+"/self", lastValues.Quantity(lastValues.Self))
+	mm.publishPower(installationTopic+"/to_ext", lastValues.Quantity(lastValues.ToExt))
+	mm.publishPower(installationTopic+"/conso_total", lastValues.Quantity(lastValues.ConsoTotal))
+	mm.publishPower(installationTopic+"/from_ext", lastValues.Quantity(lastValues.FromExt))
+	mm.publishPower(installationTopic+"/storage_charging_total", lastValues.Quantity(lastValues.StorageChargingTotal))
+	mm.publishPower(installationTopic+"/storage_discharging_total", lastValues.Quantity(lastValues.StorageDischargingTotal))
+	mm.mqttClient.PublishAndLogError(installationTopic+"/interval", formatSeconds(lastValues.Interval))
+	mm.mqttClient.PublishAndLogError(installationTopic+"/timestamp", timestamp)
 
 	for i := range lastValues.Meters {
-		meterValue := lastValues.Meters[i]
+		mm.publishMeterLiveValue(installationId, lastValues.Type, lastValues.Meters[i], lastValues.Timestamp, lastValues.Interval)
+	}
+}
+
+// publishConsumptionLiveValue publishes the last values of the non-electricity meters (heating, water and charge
+// points) under a topic per meter type, together with their unit.
+func (mm *MeterMqttModule) publishConsumptionLiveValue(installationId string, lastValues climkit.MeterData) {
+	timestamp := lastValues.Timestamp.Format(time.RFC3339)
+	typeTopic := "installation/" + installationId + "/" + string(lastValues.Type)
+	unit := lastValues.Type.Unit()
+
+	mm.mqttClient.PublishAndLogError(typeTopic+"/total", fmt.Sprintf("%f", lastValues.ConsoTotal))
+	mm.publishQuantity(typeTopic+"/total", lastValues.Quantity(lastValues.ConsoTotal))
+	mm.mqttClient.PublishAndLogError(typeTopic+"/unit", unit)
+	mm.mqttClient.PublishAndLogError(typeTopic+"/interval", formatSeconds(lastValues.Interval))
+	mm.mqttClient.PublishAndLogError(typeTopic+"/timestamp", timestamp)
 
-		mm.mqttClient.PublishAndLogError("installation/"+installationId+"/meters/"+meterValue.MeterId+"/ext", fmt.Sprintf("%f", meterValue.Ext*4))
-		mm.mqttClient.PublishAndLogError("installation/"+installationId+"/meters/"+meterValue.MeterId+"/self", fmt.Sprintf("%f", meterValue.Self*4))
-		mm.mqttClient.PublishAndLogError("installation/"+installationId+"/meters/"+meterValue.MeterId+"/total", fmt.Sprintf("%f", meterValue.Total*4))
-		mm.mqttClient.PublishAndLogError("installation/"+installationId+"/meters/"+meterValue.MeterId+"/timestamp", timestamp)
+	for i := range lastValues.Meters {
+		mm.publishMeterLiveValue(installationId, lastValues.Type, lastValues.Meters[i], lastValues.Timestamp, lastValues.Interval)
 	}
 }
+
+// publishMeterLiveValue publishes the values of one meter. Electricity values are published as average power (kW) on
+// their topic, the other ones as measured (kWh or m³, see the unit topic). Their "energy" (kWh), "power" (kW) or
+// "volume" (m³) subtopics hold the typed values. In JSON mode, the meter topic holds all of them in one message.
+func (mm *MeterMqttModule) publishMeterLiveValue(installationId string, meterType climkit.MeterType, meterValue climkit.MeterDataItem, valueTime time.Time, interval time.Duration) {
+	meterTopic := "installation/" + installationId + "/meters/" + meterValue.MeterId
+	if mm.payloadFormat == config.PayloadJSON {
+		mm.publishJSON(meterTopic, newMeterPayload(installationId, meterType, meterValue, valueTime, interval))
+		return
+	}
+	timestamp := valueTime.Format(time.RFC3339)
+
+	if meterType == climkit.Electricity {
+		mm.publishPower(meterTopic+"/ext", climkit.NewQuantity(meterType, meterValue.Ext, interval))
+		mm.publishPower(meterTopic+"/self", climkit.NewQuantity(meterType, meterValue.Self, interval))
+		mm.publishPower(meterTopic+"/total", climkit.NewQuantity(meterType, meterValue.Total, interval))
+	} else {
+		mm.mqttClient.PublishAndLogError(meterTopic+"/total", fmt.Sprintf("%f", meterValue.Total))
+		mm.publishQuantity(meterTopic+"/total", climkit.NewQuantity(meterType, meterValue.Total, interval))
+		mm.mqttClient.PublishAndLogError(meterTopic+"/unit", meterType.Unit())
+		if meterType == climkit.ChargePoint {
+			mm.mqttClient.PublishAndLogError(meterTopic+"/sessions", fmt.Sprintf("%d", meterValue.Sessions))
+		}
+	}
+	mm.mqttClient.PublishAndLogError(meterTopic+"/interval", formatSeconds(interval))
+	mm.mqttClient.PublishAndLogError(meterTopic+"/timestamp", timestamp)
+}
+
+// publishPower publishes the average power (kW) of an energy on the topic, and the typed values on its subtopics.
+func (mm *MeterMqttModule) publishPower(topic string, quantity climkit.Quantity) {
+	mm.mqttClient.PublishAndLogError(topic, fmt.Sprintf("%f", quantity.Power))
+	mm.publishQuantity(topic, quantity)
+}
+
+// publishQuantity publishes a value as topic/energy (kWh) and topic/power (kW), or topic/volume (m³).
+func (mm *MeterMqttModule) publishQuantity(topic string, quantity climkit.Quantity) {
+	if quantity.IsVolume {
+		mm.mqttClient.PublishAndLogError(topic+"/volume", fmt.Sprintf("%f", quantity.Volume))
+		return
+	}
+	mm.mqttClient.PublishAndLogError(topic+"/energy", fmt.Sprintf("%f", quantity.Energy))
+	mm.mqttClient.PublishAndLogError(topic+"/power", fmt.Sprintf("%f", quantity.Power))
+}
+
+func (mm *MeterMqttModule) publishJSON(topic string, payload interface{}) {
+	message, err := json.Marshal(payload)
+	if err != nil {
+		mm.log.Error().Str("topic", topic).Err(err).Msg("Unable to serialize the payload")
+		return
+	}
+	mm.mqttClient.PublishAndLogError(topic, message)
+}
+
+// formatSeconds formats the length of a sample, in seconds.
+func formatSeconds(interval time.Duration) string {
+	return fmt.Sprintf("%d", int(interval.Seconds()))
+}
+
+func (mm *MeterMqttModule) publishMeterRawReading(installationId string, meterType climkit.MeterType, reading climkit.RawReading) {
+	rawTopic := "installation/" + installationId + "/meters/" + reading.MeterId + "/raw"
+
+	mm.mqttClient.PublishRetainedAndLogError(rawTopic+"/value", fmt.Sprintf("%f", reading.Value))
+	mm.mqttClient.PublishRetainedAndLogError(rawTopic+"/unit", meterType.Unit())
+	mm.mqttClient.PublishRetainedAndLogError(rawTopic+"/timestamp", reading.Timestamp.Format(time.RFC3339))
+}
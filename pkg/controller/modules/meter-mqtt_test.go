@@ -0,0 +1,145 @@
+package modules
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gaetancollaud/climkit/pkg/climkit"
+	"github.com/gaetancollaud/climkit/pkg/climkit/climkittest"
+	"github.com/gaetancollaud/climkit/pkg/config"
+	"github.com/gaetancollaud/climkit/pkg/mqtt"
+)
+
+func TestMeterMqttModule(t *testing.T) {
+	server := climkittest.NewServer()
+	defer server.Close()
+	server.AddInstallation("inst-1", climkit.InstallationInfo{Name: "Home", Timezone: "Europe/Zurich"})
+	server.AddMeter("inst-1", climkit.MeterInfo{Id: "m-1", Type: string(climkit.Electricity), PrimAd: 3, Virtual: true})
+	now := time.Now().Truncate(time.Minute)
+	server.GenerateSeries("inst-1", climkit.Electricity, now.Add(-2*time.Hour), now)
+	server.AddRawReadings("inst-1", "m-1", climkit.RawReading{MeterId: "m-1", Value: 1234.5, Timestamp: now.Add(-30 * time.Minute)})
+	client, err := climkit.NewClient(server.ClientOptions())
+	if err != nil {
+		t.Fatalf("unable to create the client: %v", err)
+	}
+
+	mqttClient := newFakeMqttClient("climkit/home")
+	module := NewMeterMqttModule(mqttClient, nil, Account{Label: "home", Climkit: client}, &config.Config{
+		Mqtt: config.ConfigMqtt{
+			PayloadFormat: config.PayloadFields,
+			StaleAfter:    time.Hour,
+			HomeAssistant: config.ConfigHomeAssistant{Enabled: true, DiscoveryPrefix: "homeassistant"},
+		},
+	})
+	if !module.Eligible() {
+		t.Fatal("the module is not eligible with an MQTT client")
+	}
+	if err := module.Start(); err != nil {
+		t.Fatalf("unable to start: %v", err)
+	}
+	waitFor(t, "the raw reading", func() bool {
+		_, found := mqttClient.last("climkit/home/installation/inst-1/meters/m-1/raw/value")
+		return found
+	})
+	if err := module.Stop(); err != nil {
+		t.Fatalf("unable to stop: %v", err)
+	}
+
+	tests := []struct {
+		topic    string
+		payload  string
+		retained bool
+	}{
+		{"climkit/home/installation/inst-1/name", "Home", false},
+		{"climkit/home/installation/inst-1/meters/m-1/type", "electricity", false},
+		{"climkit/home/installation/inst-1/meters/m-1/prim_ad", "3", false},
+		{"climkit/home/installation/inst-1/meters/m-1/virtual", "true", false},
+		{"climkit/home/installation/inst-1/meters/m-1/decommissioned", "false", true},
+		{"climkit/home/installation/inst-1/interval", "900", false},
+		{"climkit/home/installation/inst-1/meters/m-1/raw/value", "1234.500000", true},
+		{"climkit/home/installation/inst-1/status", mqtt.Online, true},
+	}
+	for _, test := range tests {
+		message, found := mqttClient.last(test.topic)
+		if !found {
+			t.Errorf("%s: not published", test.topic)
+			continue
+		}
+		if message.payload != test.payload || message.retained != test.retained {
+			t.Errorf("%s: got %q (retained %t), want %q (retained %t)", test.topic, message.payload, message.retained, test.payload, test.retained)
+		}
+	}
+	if _, found := mqttClient.last("climkit/home/installation/inst-1/prod_total/power"); !found {
+		t.Error("the production power is not published")
+	}
+
+	// the ids hold the account label, another account may see the same installation.
+	discoveryTopic := "homeassistant/sensor/climkit_home_inst-1/climkit_home_inst-1_m-1_index/config"
+	message, found := mqttClient.last(discoveryTopic)
+	if !found || !message.retained {
+		t.Fatalf("%s: not published retained", discoveryTopic)
+	}
+	var sensor haSensorConfig
+	if err := json.Unmarshal([]byte(message.payload), &sensor); err != nil {
+		t.Fatalf("%s: invalid config: %v", discoveryTopic, err)
+	}
+	if sensor.UniqueId != "climkit_home_inst-1_m-1_index" || sensor.ObjectId != sensor.UniqueId {
+		t.Errorf("got ids %s and %s", sensor.UniqueId, sensor.ObjectId)
+	}
+	if sensor.StateTopic != "climkit/home/installation/inst-1/meters/m-1/raw/value" {
+		t.Errorf("got state topic %s", sensor.StateTopic)
+	}
+	if len(sensor.Device.Identifiers) != 1 || sensor.Device.Identifiers[0] != "climkit_home_inst-1" {
+		t.Errorf("got device identifiers %v", sensor.Device.Identifiers)
+	}
+}
+
+func TestMeterMqttModuleDecommissionsAfterRestart(t *testing.T) {
+	server := climkittest.NewServer()
+	defer server.Close()
+	server.AddInstallation("inst-1", climkit.InstallationInfo{Name: "Home", Timezone: "Europe/Zurich"})
+	server.AddMeter("inst-1", climkit.MeterInfo{Id: "m-1", Type: string(climkit.Electricity)})
+	client, err := climkit.NewClient(server.ClientOptions())
+	if err != nil {
+		t.Fatalf("unable to create the client: %v", err)
+	}
+
+	// m-2 was removed while the bridge was down, only its retained messages are left.
+	mqttClient := newFakeMqttClient("climkit/home")
+	mqttClient.retained = map[string]string{
+		"installation/inst-1/meters/m-1/decommissioned": "false",
+		"installation/inst-1/meters/m-2/decommissioned": "false",
+		"installation/inst-1/meters/m-3/decommissioned": "true",
+	}
+	module := NewMeterMqttModule(mqttClient, nil, Account{Label: "home", Climkit: client}, &config.Config{
+		Mqtt: config.ConfigMqtt{
+			PayloadFormat: config.PayloadFields,
+			HomeAssistant: config.ConfigHomeAssistant{Enabled: true, DiscoveryPrefix: "homeassistant"},
+		},
+	})
+	if err := module.Start(); err != nil {
+		t.Fatalf("unable to start: %v", err)
+	}
+	waitFor(t, "the decommissioned meter", func() bool {
+		message, found := mqttClient.last("climkit/home/installation/inst-1/meters/m-2/decommissioned")
+		return found && message.payload == "true"
+	})
+	if err := module.Stop(); err != nil {
+		t.Fatalf("unable to stop: %v", err)
+	}
+
+	if message, found := mqttClient.last("climkit/home/installation/inst-1/meters/m-1/decommissioned"); !found || message.payload != "false" {
+		t.Errorf("m-1: got %+v, want still in service", message)
+	}
+	if _, found := mqttClient.last("climkit/home/installation/inst-1/meters/m-3/decommissioned"); found {
+		t.Error("m-3: flagged again")
+	}
+	// the type of m-2 is not retained, the sensors of every type are removed.
+	for _, sensor := range []string{"index", "power", "sessions"} {
+		topic := "homeassistant/sensor/climkit_home_inst-1/climkit_home_inst-1_m-2_" + sensor + "/config"
+		if message, found := mqttClient.last(topic); !found || message.payload != "" || !message.retained {
+			t.Errorf("%s: got %+v, want an empty retained config", topic, message)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package modules
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"github.com/gaetancollaud/climkit/pkg/climkit"
 	"github.com/gaetancollaud/climkit/pkg/config"
 	"github.com/gaetancollaud/climkit/pkg/mqtt"
@@ -14,20 +16,36 @@ import (
 )
 
 type MeterPostgresModule struct {
-	log              zerolog.Logger
-	postgresClient   postgres.Client
-	climkit          climkit.Client
-	timerQuitChannel chan struct{}
-	installations    map[string]([]climkit.MeterInfo)
+	log            zerolog.Logger
+	postgresClient postgres.Client
+	climkit        climkit.Client
+	ctx            context.Context
+	cancel         context.CancelFunc
+	done           chan struct{}
+	installations  installationMeters
+	meter          string
+	// account is the label of the Climkit account, linked to its installations.
+	account string
+	// discoveryInterval is the schedule of the rediscovery of the installations and meters, zero disables it.
+	discoveryInterval time.Duration
+	// storeFailed is set when an installation or meter could not be stored, the next tick discovers them again.
+	storeFailed bool
 }
 
-func NewMeterPostgresModule(_ mqtt.Client, postgresClient postgres.Client, climkitClient climkit.Client, config *config.Config) Module {
-	logger := log.With().Str("Component", "MeterPostgresModule").Logger()
+func NewMeterPostgresModule(_ mqtt.Client, postgresClient postgres.Client, account Account, config *config.Config) Module {
+	logContext := log.With().Str("Component", "MeterPostgresModule")
+	if account.Label != "" {
+		logContext = logContext.Str("account", account.Label)
+	}
+	logger := logContext.Logger()
 	return &MeterPostgresModule{
-		postgresClient: postgresClient,
-		climkit:        climkitClient,
-		log:            logger,
-		installations:  make(map[string]([]climkit.MeterInfo)),
+		postgresClient:    postgresClient,
+		climkit:           account.Climkit,
+		log:               logger,
+		installations:     make(installationMeters),
+		meter:             config.Meter,
+		account:           account.Label,
+		discoveryInterval: config.DiscoveryInterval,
 	}
 }
 
@@ -36,20 +54,32 @@ func (mm *MeterPostgresModule) Eligible() bool {
 }
 
 func (mm *MeterPostgresModule) Start() error {
-	mm.fetchAndUpdateInstallationInformation()
-	mm.fetchAndUpdateInstallationHistory()
-
-	ticker := time.NewTicker(15 * time.Minute)
-	mm.timerQuitChannel = make(chan struct{})
+	mm.ctx, mm.cancel = context.WithCancel(context.Background())
+	mm.done = make(chan struct{})
 
 	go func() {
+		defer close(mm.done)
+		mm.discover(mm.ctx)
+		mm.fetchAndUpdateInstallationHistory()
+		mm.fetchAndUpdateRawReadings()
+
+		ticker := time.NewTicker(15 * time.Minute)
+		defer ticker.Stop()
+		discoveryTicker, stopDiscovery := discoveryTicks(mm.discoveryInterval)
+		defer stopDiscovery()
 		for {
 			select {
+			case <-discoveryTicker:
+				// the cached metadata would hide the added and removed meters.
+				mm.discover(climkit.WithFreshMetadata(mm.ctx))
 			case <-ticker.C:
+				if mm.storeFailed {
+					mm.discover(mm.ctx)
+				}
 				mm.fetchAndUpdateInstallationHistory()
-			case <-mm.timerQuitChannel:
+				mm.fetchAndUpdateRawReadings()
+			case <-mm.ctx.Done():
 				mm.log.Info().Msg("Stopping interval requests")
-				ticker.Stop()
 				return
 			}
 		}
@@ -57,8 +87,10 @@ func (mm *MeterPostgresModule) Start() error {
 	return nil
 }
 
+// Stop cancels the in-flight requests and waits for the interval loop to return.
 func (mm *MeterPostgresModule) Stop() error {
-	close(mm.timerQuitChannel)
+	mm.cancel()
+	<-mm.done
 	return nil
 }
 
@@ -66,95 +98,356 @@ func init() {
 	Register("meter-postgres", NewMeterPostgresModule)
 }
 
-func (mm *MeterPostgresModule) fetchAndUpdateInstallationInformation() {
-	installationIds, err := mm.climkit.GetInstallationIds()
-	if err != nil {
-		mm.log.Error().Err(err).Msg("Unable to get installations liost")
+// discover stores the installations and meters returned by the API and flags the meters that disappeared as
+// decommissioned. They are not deleted, their history is kept.
+func (mm *MeterPostgresModule) discover(ctx context.Context) {
+	if len(mm.installations) == 0 {
+		// after a restart, compare with the stored meters, so that the meters removed meanwhile are flagged too.
+		mm.installations = mm.getActiveMeters()
+	}
+	discovered, ok := mm.fetchAndUpdateInstallationInformation(ctx)
+	if !ok {
+		return
+	}
+	diff := diffInstallations(mm.installations, discovered)
+	diff.log(mm.log)
+	for _, removed := range diff.removedMeters {
+		mm.decommissionMeter(removed.installationId, removed.meter.Id)
+	}
+	mm.installations = discovered
+}
+
+// fetchAndUpdateInstallationInformation returns the installations and meters currently returned by the API,
+// and false if the discovery was interrupted. An installation that cannot be fetched keeps its known meters, unless it
+// is not found anymore.
+func (mm *MeterPostgresModule) fetchAndUpdateInstallationInformation(ctx context.Context) (installationMeters, bool) {
+	installationIds, action := callClimkit(ctx, mm.log, func() ([]string, error) {
+		return mm.climkit.GetInstallationIdsContext(ctx)
+	})
+	if action != actionNone {
+		return nil, false
 	}
 	mm.log.Info().Strs("installationIds", installationIds).Msg("installation retrieved")
 
+	discovered := make(installationMeters)
+	mm.storeFailed = false
+
 	for i := range installationIds {
 		installationId := installationIds[i]
-		info, err := mm.climkit.GetInstallationInfo(installationId)
-		if err != nil {
-			mm.log.Error().Err(err).Msg("Unable to get installation information")
+		logger := mm.log.With().Str("installationId", installationId).Logger()
+
+		info, action := callClimkit(ctx, logger, func() (climkit.InstallationInfo, error) {
+			return mm.climkit.GetInstallationInfoContext(ctx, installationId)
+		})
+		if action == actionAbort {
+			return nil, false
+		} else if action == actionFail {
+			discovered.keepKnown(mm.installations, installationId)
+			continue
+		} else if action != actionNone {
+			continue
 		}
 		infoStr, _ := json.Marshal(info)
 		mm.log.Info().RawJSON("info", infoStr).Msg("got installation info")
-		mm.updateInstallation(installationId, info)
+		if err := mm.updateInstallation(installationId, info); err != nil {
+			logger.Error().Err(err).Msg("Unable to update installation, retrying at the next tick")
+			mm.storeFailed = true
+			discovered.keepKnown(mm.installations, installationId)
+			continue
+		}
 
-		meters, err := mm.climkit.GetMetersInfos(installationIds[i])
+		meters, action := callClimkit(ctx, logger, func() ([]climkit.MeterInfo, error) {
+			return mm.climkit.GetMetersInfosContext(ctx, installationId)
+		})
+		if action == actionAbort {
+			return nil, false
+		} else if action == actionFail {
+			discovered.keepKnown(mm.installations, installationId)
+			continue
+		} else if action != actionNone {
+			continue
+		}
 		metersStr, _ := json.Marshal(meters)
 		mm.log.Info().RawJSON("meters", metersStr).Msg("got installation meters")
 
+		var meterErr error
 		for j := range meters {
 			meterInfo := meters[j]
-			mm.updateMeterInfo(installationId, meterInfo)
+			if err := mm.updateMeterInfo(installationId, meterInfo); err != nil {
+				logger.Error().Err(err).Str("MeterId", meterInfo.Id).Msg("Unable to update meter, retrying at the next tick")
+				meterErr = err
+			}
+		}
+		if meterErr != nil {
+			// the values of an unstored meter cannot be stored either.
+			mm.storeFailed = true
+			discovered.keepKnown(mm.installations, installationId)
+			continue
 		}
 
-		mm.installations[installationId] = meters
+		sensors, action := callClimkit(ctx, logger, func() ([]climkit.Sensor, error) {
+			return mm.climkit.GetSensorsContext(ctx, installationId)
+		})
+		if action == actionAbort {
+			return nil, false
+		}
+		sensorsStr, _ := json.Marshal(sensors)
+		mm.log.Info().RawJSON("sensors", sensorsStr).Msg("got installation sensors")
+
+		for _, sensor := range sensors {
+			mm.updateSensorInfo(installationId, sensor)
+		}
+
+		discovered[installationId] = meters
 	}
+	return discovered, true
 }
+
 func (mm *MeterPostgresModule) fetchAndUpdateInstallationHistory() {
+	if mm.meter != "" {
+		mm.fetchAndUpdateSingleMeterHistory()
+		return
+	}
+	// the history must not delay the live requests sharing the rate limit.
+	ctx := climkit.WithPriority(mm.ctx, climkit.PriorityLow)
 	now := time.Now()
-	interval := time.Hour * 24 * 30 // 1 month
 	for installationId, meters := range mm.installations {
-		startTime := mm.getLastHistoryTime(installationId)
-		for startTime.Before(now) {
-			endTime := startTime.Add(interval)
-			mm.log.Info().Str("installation", installationId).Time("startTime", startTime).Time("endTime", endTime).Msg("Getting history")
-
-			// TODO multiple call for multiple type
-			data, err := mm.climkit.GetMeterData(installationId, meters, climkit.Electricity, startTime, endTime)
-			if err != nil {
-				log.Fatal().Str("installation", installationId).Time("startTime", startTime).Err(err).Msg("Unable to get data")
-			}
-			for _, instalData := range data {
-				timestamp := instalData.Timestamp
-
-				query := `INSERT INTO t_installation_values (installation_id, date_time, prod_total, self, to_ext)
-							  VALUES ($1, $2, $3, $4, $5)
-							  ON CONFLICT (installation_id, date_time)
-							  DO UPDATE SET prod_total=$3, self=$4, to_ext=$5`
-				err := mm.postgresClient.Execute(query,
-					installationId, timestamp, instalData.ProdTotal, instalData.Self, instalData.ToExt)
-				if err != nil {
-					mm.log.Error().Str("installation", installationId).Time("Timestamp", timestamp).Err(err).Msg("Unable to insert meter data")
+		for _, meterType := range climkit.GetMeterTypes(meters) {
+			startTime := mm.getLastHistoryTime(installationId, meterType, "")
+			mm.log.Info().Str("installation", installationId).Str("meterType", string(meterType)).Time("startTime", startTime).Time("endTime", now).Msg("Getting history")
+
+			// the client splits the range in requests the API accepts, the values are stored while the next request
+			// is downloading.
+			logger := mm.log.With().Str("installation", installationId).Str("meterType", string(meterType)).Time("startTime", startTime).Logger()
+			_, action := callClimkit(mm.ctx, logger, func() (struct{}, error) {
+				data, errs := mm.climkit.StreamMeterData(ctx, installationId, meters, meterType, startTime, now)
+				for instalData := range data {
+					if meterType == climkit.Electricity {
+						mm.insertElectricityData(installationId, instalData)
+					} else {
+						// Those meter types have no installation wide balance, only values per meter.
+						for _, meterData := range instalData.Meters {
+							mm.insertMeterValue(installationId, meterType, instalData.Timestamp, instalData.Interval, meterData)
+						}
+					}
+					// a retry after a rate limit resumes from the last stored value.
+					startTime = instalData.Timestamp
 				}
+				return struct{}{}, <-errs
+			})
+			if action == actionAbort {
+				return
+			}
+		}
+	}
+}
+
+// fetchAndUpdateSingleMeterHistory only updates the history of the configured meter.
+func (mm *MeterPostgresModule) fetchAndUpdateSingleMeterHistory() {
+	ctx := climkit.WithPriority(mm.ctx, climkit.PriorityLow)
+	now := time.Now()
+	for installationId, meters := range mm.installations {
+		meter, found := climkit.FindMeter(meters, mm.meter)
+		if !found {
+			continue
+		}
+		meterType := climkit.MeterType(meter.Type)
+		startTime := mm.getLastHistoryTime(installationId, meterType, meter.Id)
+		mm.log.Info().Str("installation", installationId).Str("meterId", meter.Id).Time("startTime", startTime).Time("endTime", now).Msg("Getting meter history")
+
+		logger := mm.log.With().Str("installation", installationId).Str("meterId", meter.Id).Time("startTime", startTime).Logger()
+		_, action := callClimkit(mm.ctx, logger, func() (struct{}, error) {
+			data, errs := mm.climkit.StreamSingleMeterData(ctx, installationId, meter.Id, startTime, now)
+			for meterData := range data {
+				mm.insertMeterValue(installationId, meterType, meterData.Timestamp, meterData.Interval, meterData.MeterDataItem)
+				startTime = meterData.Timestamp
+			}
+			return struct{}{}, <-errs
+		})
+		if action == actionAbort {
+			return
+		}
+	}
+}
+
+// fetchAndUpdateRawReadings stores the raw register readings of each meter (or only the configured meter) since the
+// last stored reading.
+func (mm *MeterPostgresModule) fetchAndUpdateRawReadings() {
+	ctx := climkit.WithPriority(mm.ctx, climkit.PriorityLow)
+	for installationId, meters := range mm.installations {
+		for _, meter := range meters {
+			if mm.meter != "" && meter.Id != mm.meter {
+				continue
+			}
+			startTime := mm.getLastRawReadingTime(installationId, meter.Id)
+			for {
+				mm.log.Info().Str("installation", installationId).Str("meterId", meter.Id).Time("startTime", startTime).Msg("Getting raw readings")
 
-				for _, meterData := range instalData.Meters {
-					query := `INSERT INTO t_meter_values (meter_id, date_time, total, self, ext)
-							  VALUES ($1, $2, $3, $4, $5)
+				logger := mm.log.With().Str("installation", installationId).Str("meterId", meter.Id).Time("startTime", startTime).Logger()
+				readings, action := callClimkit(mm.ctx, logger, func() ([]climkit.RawReading, error) {
+					return mm.climkit.GetMeterRawDataContext(ctx, installationId, meter.Id, startTime)
+				})
+				if action == actionAbort {
+					return
+				} else if action != actionNone {
+					break
+				}
+				for _, reading := range readings {
+					query := `INSERT INTO t_meter_raw_readings (meter_id, date_time, value)
+							  VALUES ($1, $2, $3)
 							  ON CONFLICT (meter_id, date_time)
-							  DO UPDATE SET total=$3, self=$4, ext=$5`
-					err := mm.postgresClient.Execute(query,
-						meterData.MeterId, timestamp, meterData.Total, meterData.Self, meterData.Ext)
+							  DO UPDATE SET value=$3`
+					err := mm.postgresClient.Execute(query, reading.MeterId, reading.Timestamp, reading.Value)
 					if err != nil {
-						mm.log.Error().Str("installation", installationId).Time("Timestamp", timestamp).Err(err).Msg("Unable to insert meter data")
+						mm.log.Error().Str("installation", installationId).Str("meterId", meter.Id).Time("Timestamp", reading.Timestamp).Err(err).Msg("Unable to insert raw reading")
 					}
 				}
+
+				// the API returns a limited number of readings, continue from the last one until we are up to date.
+				if len(readings) == 0 || !readings[len(readings)-1].Timestamp.After(startTime) {
+					break
+				}
+				startTime = readings[len(readings)-1].Timestamp
 			}
+		}
+	}
+}
 
-			// sleep to avoid "too many requests"
-			time.Sleep(2 * time.Second)
+func (mm *MeterPostgresModule) getLastRawReadingTime(installationId string, meterId string) time.Time {
+	row := mm.postgresClient.Select(`SELECT date_time FROM t_meter_raw_readings WHERE meter_id=$1 ORDER BY date_time DESC LIMIT 1 `, meterId)
+	var lastTime time.Time
+	err := row.Scan(&lastTime)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			mm.log.Error().Err(err).Str("meterId", meterId).Msg("Unable to get last raw reading")
+		}
 
-			startTime = endTime
+		row = mm.postgresClient.Select(`SELECT creation_date FROM t_installations WHERE installation_id=$1 LIMIT 1 `, installationId)
+		err = row.Scan(&lastTime)
+		if err != nil {
+			mm.log.Error().Err(err).Str("installationId", installationId).Msg("Unable to get installation creation date")
 		}
 	}
+	return lastTime
+}
+
+// insertElectricityData stores the energy balance of the installation (kWh) and its average power (kW) during the
+// sample.
+func (mm *MeterPostgresModule) insertElectricityData(installationId string, instalData climkit.MeterData) {
+	timestamp := instalData.Timestamp
+	interval := instalData.Interval
+	power := func(energy float64) float64 {
+		return float64(climkit.Energy(energy).AveragePower(interval))
+	}
+
+	query := `INSERT INTO t_installation_values (installation_id, date_time, interval_seconds,
+				  prod_total, self, to_ext, conso_total, from_ext, storage_charging_total, storage_discharging_total,
+				  prod_total_kw, self_kw, to_ext_kw, conso_total_kw, from_ext_kw, storage_charging_total_kw, storage_discharging_total_kw)
+				  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+				  ON CONFLICT (installation_id, date_time)
+				  DO UPDATE SET interval_seconds=$3,
+				  prod_total=$4, self=$5, to_ext=$6, conso_total=$7, from_ext=$8, storage_charging_total=$9, storage_discharging_total=$10,
+				  prod_total_kw=$11, self_kw=$12, to_ext_kw=$13, conso_total_kw=$14, from_ext_kw=$15, storage_charging_total_kw=$16, storage_discharging_total_kw=$17`
+	err := mm.postgresClient.Execute(query,
+		installationId, timestamp, int(interval.Seconds()),
+		instalData.ProdTotal, instalData.Self, instalData.ToExt, instalData.ConsoTotal, instalData.FromExt,
+		instalData.StorageChargingTotal, instalData.StorageDischargingTotal,
+		power(instalData.ProdTotal), power(instalData.Self), power(instalData.ToExt), power(instalData.ConsoTotal),
+		power(instalData.FromExt), power(instalData.StorageChargingTotal), power(instalData.StorageDischargingTotal))
+	if err != nil {
+		mm.log.Error().Str("installation", installationId).Time("Timestamp", timestamp).Err(err).Msg("Unable to insert meter data")
+	}
+
+	for _, meterData := range instalData.Meters {
+		mm.insertMeterValue(installationId, climkit.Electricity, timestamp, interval, meterData)
+	}
+}
+
+// insertMeterValue stores the value of one meter: electricity (kWh), heating (kWh), water (m³) or charge point
+// (sessions and kWh), with the length of the sample and the average power (kW) for the energies.
+func (mm *MeterPostgresModule) insertMeterValue(installationId string, meterType climkit.MeterType, timestamp time.Time, interval time.Duration, meterData climkit.MeterDataItem) {
+	var err error
+	intervalSeconds := int(interval.Seconds())
+	power := float64(climkit.Energy(meterData.Total).AveragePower(interval))
+	switch meterType {
+	case climkit.Electricity:
+		query := `INSERT INTO t_meter_values (meter_id, date_time, total, self, ext, interval_seconds, total_kw, self_kw, ext_kw)
+				  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+				  ON CONFLICT (meter_id, date_time)
+				  DO UPDATE SET total=$3, self=$4, ext=$5, interval_seconds=$6, total_kw=$7, self_kw=$8, ext_kw=$9`
+		err = mm.postgresClient.Execute(query, meterData.MeterId, timestamp, meterData.Total, meterData.Self, meterData.Ext,
+			intervalSeconds, power,
+			float64(climkit.Energy(meterData.Self).AveragePower(interval)),
+			float64(climkit.Energy(meterData.Ext).AveragePower(interval)))
+	case climkit.Heating:
+		query := `INSERT INTO t_heating_values (meter_id, date_time, energy_kwh, interval_seconds, power_kw)
+				  VALUES ($1, $2, $3, $4, $5)
+				  ON CONFLICT (meter_id, date_time)
+				  DO UPDATE SET energy_kwh=$3, interval_seconds=$4, power_kw=$5`
+		err = mm.postgresClient.Execute(query, meterData.MeterId, timestamp, meterData.Total, intervalSeconds, power)
+	case climkit.ColdWater, climkit.HotWater:
+		query := `INSERT INTO t_water_values (meter_id, date_time, volume_m3, interval_seconds)
+				  VALUES ($1, $2, $3, $4)
+				  ON CONFLICT (meter_id, date_time)
+				  DO UPDATE SET volume_m3=$3, interval_seconds=$4`
+		err = mm.postgresClient.Execute(query, meterData.MeterId, timestamp, meterData.Total, intervalSeconds)
+	case climkit.ChargePoint:
+		query := `INSERT INTO t_charge_point_values (meter_id, date_time, sessions, energy_kwh, interval_seconds, power_kw)
+				  VALUES ($1, $2, $3, $4, $5, $6)
+				  ON CONFLICT (meter_id, date_time)
+				  DO UPDATE SET sessions=$3, energy_kwh=$4, interval_seconds=$5, power_kw=$6`
+		err = mm.postgresClient.Execute(query, meterData.MeterId, timestamp, meterData.Sessions, meterData.Total, intervalSeconds, power)
+	default:
+		mm.log.Warn().Str("installation", installationId).Str("meterType", string(meterType)).Msg("Unsupported meter type, values not stored")
+		return
+	}
+	if err != nil {
+		mm.log.Error().Str("installation", installationId).Str("MeterId", meterData.MeterId).Time("Timestamp", timestamp).Err(err).Msg("Unable to insert meter data")
+	}
 }
 
-func (mm *MeterPostgresModule) getLastHistoryTime(installationId string) time.Time {
-	row := mm.postgresClient.Select(`SELECT date_time FROM t_installation_values WHERE installation_id=$1 ORDER BY date_time DESC LIMIT 1 `, installationId)
+// getMeterValuesTable returns the table storing the values of the meters of the given type, or an empty string if the
+// meter type is not stored.
+func getMeterValuesTable(meterType climkit.MeterType) string {
+	switch meterType {
+	case climkit.Electricity:
+		return "t_meter_values"
+	case climkit.Heating:
+		return "t_heating_values"
+	case climkit.ColdWater, climkit.HotWater:
+		return "t_water_values"
+	case climkit.ChargePoint:
+		return "t_charge_point_values"
+	default:
+		return ""
+	}
+}
+
+// getLastHistoryTime returns the time of the most recent value stored for an installation and a meter type, or only
+// for one meter if meterId is set. Falls back to the installation creation date if nothing is stored yet.
+func (mm *MeterPostgresModule) getLastHistoryTime(installationId string, meterType climkit.MeterType, meterId string) time.Time {
 	var lastTime time.Time
-	err := row.Scan(&lastTime)
+	err := sql.ErrNoRows
+	if table := getMeterValuesTable(meterType); table != "" {
+		var row *sql.Row
+		if meterId != "" {
+			row = mm.postgresClient.Select(`SELECT date_time FROM `+table+` WHERE meter_id=$1 ORDER BY date_time DESC LIMIT 1 `, meterId)
+		} else if meterType == climkit.Electricity {
+			row = mm.postgresClient.Select(`SELECT date_time FROM t_installation_values WHERE installation_id=$1 ORDER BY date_time DESC LIMIT 1 `, installationId)
+		} else {
+			row = mm.postgresClient.Select(`SELECT v.date_time FROM `+table+` v JOIN t_meters m ON m.meter_id = v.meter_id
+				WHERE m.installation_id=$1 AND m.meter_type=$2 ORDER BY v.date_time DESC LIMIT 1 `, installationId, string(meterType))
+		}
+		err = row.Scan(&lastTime)
+	}
 	if err != nil {
 		if err != sql.ErrNoRows {
-			mm.log.Error().Err(err).Str("installationId", installationId).Msg("Unable to get last installation values")
+			mm.log.Error().Err(err).Str("installationId", installationId).Str("meterType", string(meterType)).Msg("Unable to get last installation values")
 		}
 
 		//lastTime, _ = time.Parse(time.RFC3339, "2022-08-14T00:00:00Z")
 
-		row = mm.postgresClient.Select(`SELECT creation_date FROM t_installations WHERE installation_id=$1 LIMIT 1 `, installationId)
+		row := mm.postgresClient.Select(`SELECT creation_date FROM t_installations WHERE installation_id=$1 LIMIT 1 `, installationId)
 		err = row.Scan(&lastTime)
 		if err != nil {
 			mm.log.Error().Err(err).Str("installationId", installationId).Msg("Unable to get installation creation date")
@@ -164,24 +457,82 @@ func (mm *MeterPostgresModule) getLastHistoryTime(installationId string) time.Ti
 	return lastTime
 }
 
-func (mm *MeterPostgresModule) updateInstallation(installationId string, installation climkit.InstallationInfo) {
+// updateInstallation stores the installation and links it to the account, an installation visible from several
+// accounts is linked to each of them.
+func (mm *MeterPostgresModule) updateInstallation(installationId string, installation climkit.InstallationInfo) error {
 	query := `INSERT INTO t_installations(installation_id, site_ref, name, timezone, creation_date, latitude, longitude)
 		VALUES($1, $2, $3, $4, $5, $6, $7)
 		ON CONFLICT (installation_id) DO UPDATE set site_ref=$2, name=$3, timezone=$4, creation_date=$5, latitude=$6, longitude=$7`
 
 	err := mm.postgresClient.Execute(query, installationId, installation.SiteRef, installation.Name, installation.Timezone, installation.CreationDate, installation.Latitude, installation.Longitude)
 	if err != nil {
-		mm.log.Fatal().Err(err).Str("installationId", installationId).Msg("Unable to update installation")
+		return fmt.Errorf("unable to store the installation: %w", err)
 	}
+
+	query = `INSERT INTO t_installation_accounts(account, installation_id)
+		VALUES($1, $2)
+		ON CONFLICT (account, installation_id) DO NOTHING`
+	err = mm.postgresClient.Execute(query, mm.account, installationId)
+	if err != nil {
+		return fmt.Errorf("unable to link the installation to the account: %w", err)
+	}
+	return nil
 }
 
-func (mm *MeterPostgresModule) updateMeterInfo(installationId string, meter climkit.MeterInfo) {
+func (mm *MeterPostgresModule) updateMeterInfo(installationId string, meter climkit.MeterInfo) error {
 	query := `INSERT INTO t_meters(meter_id, installation_id, meter_type, prim_ad, virtual)
 		VALUES($1, $2, $3, $4, $5)
-		ON CONFLICT (meter_id) DO UPDATE set installation_id=$2, meter_type=$3, prim_ad=$4, virtual=$5`
+		ON CONFLICT (meter_id) DO UPDATE set installation_id=$2, meter_type=$3, prim_ad=$4, virtual=$5, decommissioned_at=NULL`
 
 	err := mm.postgresClient.Execute(query, meter.Id, installationId, meter.Type, meter.PrimAd, meter.Virtual)
 	if err != nil {
-		mm.log.Fatal().Err(err).Str("installationId", installationId).Str("MeterId", meter.Id).Msg("Unable to update meter")
+		return fmt.Errorf("unable to store the meter: %w", err)
+	}
+	return nil
+}
+
+func (mm *MeterPostgresModule) decommissionMeter(installationId string, meterId string) {
+	query := `UPDATE t_meters SET decommissioned_at=$2 WHERE meter_id=$1 AND decommissioned_at IS NULL`
+
+	err := mm.postgresClient.Execute(query, meterId, time.Now())
+	if err != nil {
+		mm.log.Error().Err(err).Str("installationId", installationId).Str("MeterId", meterId).Msg("Unable to decommission meter")
+	}
+}
+
+// getActiveMeters returns the stored meters of the account that are not decommissioned, by installation.
+func (mm *MeterPostgresModule) getActiveMeters() installationMeters {
+	active := make(installationMeters)
+	rows, err := mm.postgresClient.Query(`SELECT m.meter_id, m.installation_id, m.meter_type, m.prim_ad, m.virtual FROM t_meters m
+		JOIN t_installation_accounts a ON a.installation_id = m.installation_id
+		WHERE m.decommissioned_at IS NULL AND a.account=$1`, mm.account)
+	if err != nil {
+		mm.log.Error().Err(err).Msg("Unable to get the stored meters")
+		return active
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var meter climkit.MeterInfo
+		var installationId string
+		if err := rows.Scan(&meter.Id, &installationId, &meter.Type, &meter.PrimAd, &meter.Virtual); err != nil {
+			mm.log.Error().Err(err).Msg("Unable to read a stored meter")
+			continue
+		}
+		active[installationId] = append(active[installationId], meter)
+	}
+	if err := rows.Err(); err != nil {
+		mm.log.Error().Err(err).Msg("Unable to get the stored meters")
+	}
+	return active
+}
+
+func (mm *MeterPostgresModule) updateSensorInfo(installationId string, sensor climkit.Sensor) {
+	query := `INSERT INTO t_sensors(sensor_id, installation_id, sensor_type, name, unit)
+		VALUES($1, $2, $3, $4, $5)
+		ON CONFLICT (sensor_id) DO UPDATE set installation_id=$2, sensor_type=$3, name=$4, unit=$5`
+
+	err := mm.postgresClient.Execute(query, sensor.Id, installationId, sensor.Type, sensor.Name, sensor.Unit)
+	if err != nil {
+		mm.log.Error().Err(err).Str("installationId", installationId).Str("SensorId", sensor.Id).Msg("Unable to update sensor")
 	}
 }
@@ -0,0 +1,122 @@
+package modules
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gaetancollaud/climkit/pkg/climkit"
+	"github.com/gaetancollaud/climkit/pkg/climkit/climkittest"
+	"github.com/gaetancollaud/climkit/pkg/config"
+)
+
+func TestMeterPostgresModule(t *testing.T) {
+	server := climkittest.NewServer()
+	defer server.Close()
+	server.AddInstallation("inst-1", climkit.InstallationInfo{Name: "Home", Timezone: "Europe/Zurich"})
+	server.AddMeter("inst-1", climkit.MeterInfo{Id: "m-1", Type: string(climkit.Electricity)})
+	server.AddSensor("inst-1", climkit.Sensor{Id: "s-1", Type: "temperature", Name: "Outside", Unit: "°C"})
+	creation := time.Now().Truncate(climkittest.SampleInterval).Add(-2 * time.Hour)
+	server.GenerateSeries("inst-1", climkit.Electricity, creation, time.Now())
+	server.AddRawReadings("inst-1", "m-1",
+		climkit.RawReading{MeterId: "m-1", Value: 100, Timestamp: creation.Add(time.Hour)},
+		climkit.RawReading{MeterId: "m-1", Value: 101, Timestamp: creation.Add(90 * time.Minute)})
+	client, err := climkit.NewClient(server.ClientOptions())
+	if err != nil {
+		t.Fatalf("unable to create the client: %v", err)
+	}
+
+	// nothing is stored yet, the history starts at the creation of the installation.
+	postgresClient := newFakePostgresClient(func(query string, args []driver.Value) [][]driver.Value {
+		if strings.HasPrefix(query, "SELECT creation_date FROM t_installations") {
+			return [][]driver.Value{{creation}}
+		}
+		return nil
+	})
+	module := NewMeterPostgresModule(nil, postgresClient, Account{Label: "home", Climkit: client}, &config.Config{})
+	if !module.Eligible() {
+		t.Fatal("the module is not eligible with a Postgres client")
+	}
+	if err := module.Start(); err != nil {
+		t.Fatalf("unable to start: %v", err)
+	}
+	// the last reading is requested again to check there is no newer one, then upserted again.
+	waitFor(t, "the raw readings", func() bool {
+		return len(postgresClient.executed("t_meter_raw_readings")) >= 2
+	})
+	if err := module.Stop(); err != nil {
+		t.Fatalf("unable to stop: %v", err)
+	}
+
+	installations := postgresClient.executed("t_installations")
+	if len(installations) != 1 || installations[0].args[0] != "inst-1" || installations[0].args[2] != "Home" {
+		t.Errorf("got installation statements %v", installations)
+	}
+	accounts := postgresClient.executed("t_installation_accounts")
+	if len(accounts) != 1 || accounts[0].args[0] != "home" || accounts[0].args[1] != "inst-1" {
+		t.Errorf("got account statements %v", accounts)
+	}
+	if meters := postgresClient.executed("t_meters"); len(meters) != 1 || meters[0].args[0] != "m-1" {
+		t.Errorf("got meter statements %v", meters)
+	}
+	if sensors := postgresClient.executed("t_sensors"); len(sensors) != 1 || sensors[0].args[0] != "s-1" {
+		t.Errorf("got sensor statements %v", sensors)
+	}
+
+	values := postgresClient.executed("t_installation_values")
+	if len(values) == 0 {
+		t.Fatal("no installation value stored")
+	}
+	for _, value := range values {
+		timestamp := value.args[1].(time.Time)
+		if timestamp.Before(creation) {
+			t.Errorf("value at %s, before the creation of the installation", timestamp)
+		}
+		if interval := value.args[2].(int); interval != 900 {
+			t.Errorf("value at %s: got an interval of %ds, want 900s", timestamp, interval)
+		}
+	}
+	if meterValues := postgresClient.executed("t_meter_values"); len(meterValues) != len(values) {
+		t.Errorf("got %d meter values, want one per installation value (%d)", len(meterValues), len(values))
+	}
+
+	readings := postgresClient.executed("t_meter_raw_readings")
+	if readings[0].args[2] != 100.0 || readings[1].args[2] != 101.0 {
+		t.Errorf("got raw readings %v", readings)
+	}
+}
+
+func TestMeterPostgresModuleRetriesFailedStore(t *testing.T) {
+	server := climkittest.NewServer()
+	defer server.Close()
+	server.AddInstallation("inst-1", climkit.InstallationInfo{Name: "Home", Timezone: "Europe/Zurich"})
+	server.AddMeter("inst-1", climkit.MeterInfo{Id: "m-1", Type: string(climkit.Electricity)})
+	client, err := climkit.NewClient(server.ClientOptions())
+	if err != nil {
+		t.Fatalf("unable to create the client: %v", err)
+	}
+
+	postgresClient := newFakePostgresClient(nil)
+	postgresClient.fail = func(query string) error {
+		if strings.Contains(query, "INSERT INTO t_meters") {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	module := NewMeterPostgresModule(nil, postgresClient, Account{Label: "home", Climkit: client}, &config.Config{}).(*MeterPostgresModule)
+
+	// the installation is not mirrored until its meters are stored.
+	module.discover(context.Background())
+	if !module.storeFailed || len(module.installations) != 0 {
+		t.Fatalf("got installations %v (store failed %t), want none to retry", module.installations, module.storeFailed)
+	}
+
+	postgresClient.fail = nil
+	module.discover(context.Background())
+	if module.storeFailed || len(module.installations["inst-1"]) != 1 {
+		t.Errorf("got installations %v (store failed %t), want inst-1 once stored", module.installations, module.storeFailed)
+	}
+}
@@ -14,7 +14,16 @@ type Module interface {
 	Stop() error
 }
 
-type ModuleBuilder func(mqtt.Client, postgres.Client, climkit.Client, *config.Config) Module
+// Account is a Climkit login and its client. The modules are built once per account, so that a failing account does
+// not delay the others.
+type Account struct {
+	// Label namespaces the MQTT topics and the Postgres rows of the account, empty with a single unlabelled account.
+	Label   string
+	Climkit climkit.Client
+}
+
+// The MQTT client given to the builder already publishes under the topic of the account.
+type ModuleBuilder func(mqtt.Client, postgres.Client, Account, *config.Config) Module
 
 // Register stores a builder function into the registy for external access.
 // Register() can be called from init() on a module in this package and will
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"github.com/rs/zerolog"
 	"path"
+	"strings"
+	"sync"
+	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/google/uuid"
@@ -29,11 +32,19 @@ type Client interface {
 	// Publishes a message under the prefix topic of DigitalStrom.
 	Publish(topic string, message interface{}) error
 	PublishAndLogError(topic string, message interface{})
+	// Publishes a retained message, regardless of the retain option.
+	PublishRetained(topic string, message interface{}) error
+	PublishRetainedAndLogError(topic string, message interface{})
+	// Publishes a retained message to the topic as is, without the prefix (e.g. for the Home Assistant discovery).
+	PublishAbsoluteRetained(topic string, message interface{}) error
 
 	// Return the full topic for a given subpath.
 	GetFullTopic(topic string) string
 	// Returns the topic used to publish the server status.
 	ServerStatusTopic() string
+	// Returns the payloads of the retained messages matching the topic filter under the prefix, by topic relative to
+	// the prefix. The broker sends them right after the subscription, they are collected during wait.
+	ReadRetained(filter string, wait time.Duration) (map[string]string, error)
 
 	RawClient() mqtt.Client
 }
@@ -46,18 +57,25 @@ type client struct {
 
 func NewClient(options *ClientOptions) Client {
 	logger := log.With().Str("Component", "MQTT").Logger()
+	c := &client{
+		options: *options,
+		log:     logger,
+	}
+	// The broker publishes the offline status if the connection is lost without a clean disconnect (crash, OOM
+	// kill, network loss), the online status is published again on each (re)connection.
 	mqttOptions := mqtt.NewClientOptions().
 		AddBroker(options.MqttUrl).
-		SetClientID("climkit-" + uuid.New().String()).
+		SetClientID("climkit-"+uuid.New().String()).
 		SetOrderMatters(false).
 		SetUsername(options.Username).
-		SetPassword(options.Password)
-
-	return &client{
-		mqttClient: mqtt.NewClient(mqttOptions),
-		options:    *options,
-		log:        logger,
-	}
+		SetPassword(options.Password).
+		SetWill(c.ServerStatusTopic(), Offline, options.QoS, true).
+		SetOnConnectHandler(c.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logger.Warn().Err(err).Msg("Connection to the MQTT server lost, reconnecting")
+		})
+	c.mqttClient = mqtt.NewClient(mqttOptions)
+	return c
 }
 
 func (c *client) Connect() error {
@@ -66,11 +84,15 @@ func (c *client) Connect() error {
 	if t.Error() != nil {
 		return fmt.Errorf("error connecting to MQTT broker '%s': %w", c.options.MqttUrl, t.Error())
 	}
+	return nil
+}
 
+// onConnect is called on the first connection and on each reconnection, the broker may have published the will
+// meanwhile.
+func (c *client) onConnect(_ mqtt.Client) {
 	if err := c.publishServerStatus(Online); err != nil {
-		return err
+		c.log.Error().Err(err).Msg("Unable to publish the online status")
 	}
-	return nil
 }
 
 func (c *client) Disconnect() error {
@@ -84,13 +106,7 @@ func (c *client) Disconnect() error {
 }
 
 func (c *client) Publish(topic string, message interface{}) error {
-	t := c.mqttClient.Publish(
-		path.Join(c.options.TopicPrefix, topic),
-		c.options.QoS,
-		c.options.Retain,
-		message)
-	<-t.Done()
-	return t.Error()
+	return c.publish(topic, message, c.options.Retain)
 }
 
 func (c *client) PublishAndLogError(topic string, message interface{}) {
@@ -100,10 +116,37 @@ func (c *client) PublishAndLogError(topic string, message interface{}) {
 	}
 }
 
-// Publish the current binary status into the MQTT topic.
+func (c *client) PublishRetained(topic string, message interface{}) error {
+	return c.publish(topic, message, true)
+}
+
+func (c *client) PublishRetainedAndLogError(topic string, message interface{}) {
+	err := c.PublishRetained(topic, message)
+	if err != nil {
+		c.log.Error().Str("topic", topic).Err(err).Msg("Cannot publish")
+	}
+}
+
+func (c *client) PublishAbsoluteRetained(topic string, message interface{}) error {
+	t := c.mqttClient.Publish(topic, c.options.QoS, true, message)
+	<-t.Done()
+	return t.Error()
+}
+
+func (c *client) publish(topic string, message interface{}, retain bool) error {
+	t := c.mqttClient.Publish(
+		path.Join(c.options.TopicPrefix, topic),
+		c.options.QoS,
+		retain,
+		message)
+	<-t.Done()
+	return t.Error()
+}
+
+// Publish the current binary status into the MQTT topic, retained like the will.
 func (c *client) publishServerStatus(message string) error {
 	c.log.Info().Str("status", message).Str("topic", serverStatus).Msg("Updating server status topic")
-	return c.Publish(serverStatus, message)
+	return c.PublishRetained(serverStatus, message)
 }
 
 func (c *client) ServerStatusTopic() string {
@@ -114,11 +157,39 @@ func (c *client) GetFullTopic(topic string) string {
 	return path.Join(c.options.TopicPrefix, topic)
 }
 
+func (c *client) ReadRetained(filter string, wait time.Duration) (map[string]string, error) {
+	var mu sync.Mutex
+	retained := make(map[string]string)
+	fullFilter := c.GetFullTopic(filter)
+	t := c.mqttClient.Subscribe(fullFilter, c.options.QoS, func(_ mqtt.Client, message mqtt.Message) {
+		if !message.Retained() {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		retained[strings.TrimPrefix(message.Topic(), c.GetFullTopic("")+"/")] = string(message.Payload())
+	})
+	<-t.Done()
+	if t.Error() != nil {
+		return nil, fmt.Errorf("error subscribing to '%s': %w", fullFilter, t.Error())
+	}
+	time.Sleep(wait)
+	t = c.mqttClient.Unsubscribe(fullFilter)
+	<-t.Done()
+	if t.Error() != nil {
+		c.log.Warn().Str("topic", fullFilter).Err(t.Error()).Msg("Unable to unsubscribe")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return retained, nil
+}
+
 func (c *client) RawClient() mqtt.Client {
 	return c.mqttClient
 }
 
-func normalizeForTopicName(item string) string {
+// NormalizeForTopicName keeps the characters of item usable in a topic level, spaces and slashes become underscores.
+func NormalizeForTopicName(item string) string {
 	output := ""
 	for i := 0; i < len(item); i++ {
 		c := item[i]
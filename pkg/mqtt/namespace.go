@@ -0,0 +1,87 @@
+package mqtt
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// namespacedClient publishes under a sub topic of its parent client, sharing its connection.
+type namespacedClient struct {
+	parent    Client
+	namespace string
+}
+
+// NewNamespacedClient returns a client publishing under the given sub topic of the parent prefix. The connection is
+// owned by the parent: connecting or disconnecting the returned client does nothing. An empty namespace returns the
+// parent itself.
+func NewNamespacedClient(parent Client, namespace string) Client {
+	if namespace == "" {
+		return parent
+	}
+	return &namespacedClient{
+		parent:    parent,
+		namespace: NormalizeForTopicName(namespace),
+	}
+}
+
+func (c *namespacedClient) Connect() error {
+	return nil
+}
+
+func (c *namespacedClient) Disconnect() error {
+	return nil
+}
+
+func (c *namespacedClient) Publish(topic string, message interface{}) error {
+	return c.parent.Publish(c.topic(topic), message)
+}
+
+func (c *namespacedClient) PublishAndLogError(topic string, message interface{}) {
+	c.parent.PublishAndLogError(c.topic(topic), message)
+}
+
+func (c *namespacedClient) PublishRetained(topic string, message interface{}) error {
+	return c.parent.PublishRetained(c.topic(topic), message)
+}
+
+func (c *namespacedClient) PublishRetainedAndLogError(topic string, message interface{}) {
+	c.parent.PublishRetainedAndLogError(c.topic(topic), message)
+}
+
+// PublishAbsoluteRetained publishes outside of the namespace, the topic is absolute.
+func (c *namespacedClient) PublishAbsoluteRetained(topic string, message interface{}) error {
+	return c.parent.PublishAbsoluteRetained(topic, message)
+}
+
+func (c *namespacedClient) GetFullTopic(topic string) string {
+	return c.parent.GetFullTopic(c.topic(topic))
+}
+
+// ServerStatusTopic is the one of the parent, the status is the one of the connection.
+func (c *namespacedClient) ServerStatusTopic() string {
+	return c.parent.ServerStatusTopic()
+}
+
+// ReadRetained returns the topics relative to the namespace.
+func (c *namespacedClient) ReadRetained(filter string, wait time.Duration) (map[string]string, error) {
+	parentRetained, err := c.parent.ReadRetained(c.topic(filter), wait)
+	if err != nil {
+		return nil, err
+	}
+	retained := make(map[string]string, len(parentRetained))
+	for topic, payload := range parentRetained {
+		retained[strings.TrimPrefix(topic, c.namespace+"/")] = payload
+	}
+	return retained, nil
+}
+
+func (c *namespacedClient) RawClient() mqtt.Client {
+	return c.parent.RawClient()
+}
+
+func (c *namespacedClient) topic(topic string) string {
+	return path.Join(c.namespace, topic)
+}
@@ -24,6 +24,7 @@ type Client interface {
 	Migrate() error
 	Execute(query string, args ...any) error
 	Select(query string, args ...any) *sql.Row
+	Query(query string, args ...any) (*sql.Rows, error)
 }
 
 type client struct {
@@ -101,6 +102,10 @@ func (c *client) Select(query string, args ...any) *sql.Row {
 	return c.db.QueryRow(query, args...)
 }
 
+func (c *client) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.db.Query(query, args...)
+}
+
 func (c *client) Execute(query string, args ...any) error {
 	exec, err := c.db.Exec(query, args...)
 	if err != nil {
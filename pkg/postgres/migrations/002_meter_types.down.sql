@@ -0,0 +1,3 @@
+DROP TABLE t_heating_values;
+DROP TABLE t_water_values;
+DROP TABLE t_charge_point_values;
@@ -0,0 +1,33 @@
+CREATE TABLE t_heating_values
+(
+    meter_id   VARCHAR                  NOT NULL,
+    date_time  TIMESTAMP WITH TIME ZONE NOT NULL,
+    energy_kwh DOUBLE PRECISION         NOT NULL,
+    PRIMARY KEY (meter_id, date_time),
+    CONSTRAINT heating_values_meter_id
+        FOREIGN KEY (meter_id)
+            REFERENCES t_meters (meter_id)
+);
+
+CREATE TABLE t_water_values
+(
+    meter_id  VARCHAR                  NOT NULL,
+    date_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    volume_m3 DOUBLE PRECISION         NOT NULL,
+    PRIMARY KEY (meter_id, date_time),
+    CONSTRAINT water_values_meter_id
+        FOREIGN KEY (meter_id)
+            REFERENCES t_meters (meter_id)
+);
+
+CREATE TABLE t_charge_point_values
+(
+    meter_id   VARCHAR                  NOT NULL,
+    date_time  TIMESTAMP WITH TIME ZONE NOT NULL,
+    sessions   INTEGER                  NOT NULL,
+    energy_kwh DOUBLE PRECISION         NOT NULL,
+    PRIMARY KEY (meter_id, date_time),
+    CONSTRAINT charge_point_values_meter_id
+        FOREIGN KEY (meter_id)
+            REFERENCES t_meters (meter_id)
+);
@@ -0,0 +1 @@
+DROP TABLE t_meter_raw_readings;
@@ -0,0 +1,10 @@
+CREATE TABLE t_meter_raw_readings
+(
+    meter_id  VARCHAR                  NOT NULL,
+    date_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    value     DOUBLE PRECISION         NOT NULL,
+    PRIMARY KEY (meter_id, date_time),
+    CONSTRAINT meter_raw_readings_meter_id
+        FOREIGN KEY (meter_id)
+            REFERENCES t_meters (meter_id)
+);
@@ -0,0 +1,11 @@
+CREATE TABLE t_sensors
+(
+    sensor_id       VARCHAR PRIMARY KEY NOT NULL,
+    installation_id VARCHAR             NOT NULL,
+    sensor_type     VARCHAR             NOT NULL,
+    name            VARCHAR,
+    unit            VARCHAR,
+    CONSTRAINT sensors_installation_id
+        FOREIGN KEY (installation_id)
+            REFERENCES t_installations (installation_id)
+);
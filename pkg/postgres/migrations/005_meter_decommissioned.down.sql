@@ -0,0 +1,2 @@
+ALTER TABLE t_meters
+    DROP COLUMN decommissioned_at;
@@ -0,0 +1,2 @@
+ALTER TABLE t_meters
+    ADD COLUMN decommissioned_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,22 @@
+ALTER TABLE t_installation_values
+    DROP COLUMN interval_seconds,
+    DROP COLUMN prod_total_kw,
+    DROP COLUMN self_kw,
+    DROP COLUMN to_ext_kw;
+
+ALTER TABLE t_meter_values
+    DROP COLUMN interval_seconds,
+    DROP COLUMN total_kw,
+    DROP COLUMN self_kw,
+    DROP COLUMN ext_kw;
+
+ALTER TABLE t_heating_values
+    DROP COLUMN interval_seconds,
+    DROP COLUMN power_kw;
+
+ALTER TABLE t_water_values
+    DROP COLUMN interval_seconds;
+
+ALTER TABLE t_charge_point_values
+    DROP COLUMN interval_seconds,
+    DROP COLUMN power_kw;
@@ -0,0 +1,44 @@
+ALTER TABLE t_installation_values
+    ADD COLUMN interval_seconds INTEGER,
+    ADD COLUMN prod_total_kw    DOUBLE PRECISION,
+    ADD COLUMN self_kw          DOUBLE PRECISION,
+    ADD COLUMN to_ext_kw        DOUBLE PRECISION;
+
+ALTER TABLE t_meter_values
+    ADD COLUMN interval_seconds INTEGER,
+    ADD COLUMN total_kw         DOUBLE PRECISION,
+    ADD COLUMN self_kw          DOUBLE PRECISION,
+    ADD COLUMN ext_kw           DOUBLE PRECISION;
+
+ALTER TABLE t_heating_values
+    ADD COLUMN interval_seconds INTEGER,
+    ADD COLUMN power_kw         DOUBLE PRECISION;
+
+ALTER TABLE t_water_values
+    ADD COLUMN interval_seconds INTEGER;
+
+ALTER TABLE t_charge_point_values
+    ADD COLUMN interval_seconds INTEGER,
+    ADD COLUMN power_kw         DOUBLE PRECISION;
+
+COMMENT ON COLUMN t_installation_values.prod_total IS 'Energy produced during the interval, in kWh';
+COMMENT ON COLUMN t_installation_values.self IS 'Energy self-consumed during the interval, in kWh';
+COMMENT ON COLUMN t_installation_values.to_ext IS 'Energy exported to the grid during the interval, in kWh';
+COMMENT ON COLUMN t_installation_values.interval_seconds IS 'Length of the sample, in seconds';
+COMMENT ON COLUMN t_installation_values.prod_total_kw IS 'Average production power during the interval, in kW';
+COMMENT ON COLUMN t_installation_values.self_kw IS 'Average self-consumption power during the interval, in kW';
+COMMENT ON COLUMN t_installation_values.to_ext_kw IS 'Average export power during the interval, in kW';
+
+COMMENT ON COLUMN t_meter_values.total IS 'Energy consumed during the interval, in kWh';
+COMMENT ON COLUMN t_meter_values.self IS 'Self-produced energy consumed during the interval, in kWh';
+COMMENT ON COLUMN t_meter_values.ext IS 'Grid energy consumed during the interval, in kWh';
+COMMENT ON COLUMN t_meter_values.interval_seconds IS 'Length of the sample, in seconds';
+COMMENT ON COLUMN t_meter_values.total_kw IS 'Average power during the interval, in kW';
+COMMENT ON COLUMN t_meter_values.self_kw IS 'Average self-produced power during the interval, in kW';
+COMMENT ON COLUMN t_meter_values.ext_kw IS 'Average grid power during the interval, in kW';
+
+COMMENT ON COLUMN t_heating_values.interval_seconds IS 'Length of the sample, in seconds';
+COMMENT ON COLUMN t_heating_values.power_kw IS 'Average power during the interval, in kW';
+COMMENT ON COLUMN t_water_values.interval_seconds IS 'Length of the sample, in seconds';
+COMMENT ON COLUMN t_charge_point_values.interval_seconds IS 'Length of the sample, in seconds';
+COMMENT ON COLUMN t_charge_point_values.power_kw IS 'Average charging power during the interval, in kW';
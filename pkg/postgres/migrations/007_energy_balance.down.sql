@@ -0,0 +1,9 @@
+ALTER TABLE t_installation_values
+    DROP COLUMN conso_total,
+    DROP COLUMN from_ext,
+    DROP COLUMN storage_charging_total,
+    DROP COLUMN storage_discharging_total,
+    DROP COLUMN conso_total_kw,
+    DROP COLUMN from_ext_kw,
+    DROP COLUMN storage_charging_total_kw,
+    DROP COLUMN storage_discharging_total_kw;
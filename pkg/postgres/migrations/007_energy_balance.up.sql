@@ -0,0 +1,18 @@
+ALTER TABLE t_installation_values
+    ADD COLUMN conso_total                  DOUBLE PRECISION,
+    ADD COLUMN from_ext                     DOUBLE PRECISION,
+    ADD COLUMN storage_charging_total       DOUBLE PRECISION,
+    ADD COLUMN storage_discharging_total    DOUBLE PRECISION,
+    ADD COLUMN conso_total_kw               DOUBLE PRECISION,
+    ADD COLUMN from_ext_kw                  DOUBLE PRECISION,
+    ADD COLUMN storage_charging_total_kw    DOUBLE PRECISION,
+    ADD COLUMN storage_discharging_total_kw DOUBLE PRECISION;
+
+COMMENT ON COLUMN t_installation_values.conso_total IS 'Energy consumed during the interval, in kWh';
+COMMENT ON COLUMN t_installation_values.from_ext IS 'Energy imported from the grid during the interval, in kWh';
+COMMENT ON COLUMN t_installation_values.storage_charging_total IS 'Energy charged into the storage during the interval, in kWh';
+COMMENT ON COLUMN t_installation_values.storage_discharging_total IS 'Energy discharged from the storage during the interval, in kWh';
+COMMENT ON COLUMN t_installation_values.conso_total_kw IS 'Average consumption power during the interval, in kW';
+COMMENT ON COLUMN t_installation_values.from_ext_kw IS 'Average import power during the interval, in kW';
+COMMENT ON COLUMN t_installation_values.storage_charging_total_kw IS 'Average storage charging power during the interval, in kW';
+COMMENT ON COLUMN t_installation_values.storage_discharging_total_kw IS 'Average storage discharging power during the interval, in kW';
@@ -0,0 +1,12 @@
+CREATE TABLE t_installation_accounts
+(
+    account         VARCHAR NOT NULL,
+    installation_id VARCHAR NOT NULL,
+    PRIMARY KEY (account, installation_id),
+    CONSTRAINT installation_accounts_installation_id
+        FOREIGN KEY (installation_id)
+            REFERENCES t_installations (installation_id)
+);
+
+COMMENT ON TABLE t_installation_accounts IS 'Climkit accounts the installations are fetched with, an installation may be visible from several accounts';
+COMMENT ON COLUMN t_installation_accounts.account IS 'Label of the Climkit account, empty for a single unlabelled account';